@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"math"
+	"sync"
+
+	"github.com/pierrre/geohash"
+	"github.com/redis/go-redis/v9"
+)
+
+// regionOf maps a driver geohash to its sharding region, the first
+// regionPrecision characters. A precision of 3 gives cells of roughly
+// 156km x 156km, so a city stays on one shard while the whole dataset is spread
+// over a few hundred regions.
+func regionOf(geoHash string) string {
+	if len(geoHash) < regionPrecision {
+		return geoHash
+	}
+	return geoHash[:regionPrecision]
+}
+
+// regionsInRadius returns every region overlapping the bounding box of the circle.
+func regionsInRadius(center Location, radiusKm float64) []string {
+	const kmPerDegree = 111.32
+	dLat := radiusKm / kmPerDegree
+	dLng := radiusKm / (kmPerDegree * math.Max(math.Cos(center.Lat*math.Pi/180), 0.01))
+
+	// Step through the box one region cell at a time
+	cell, _ := geohash.Decode(geohash.Encode(center.Lat, center.Long, regionPrecision))
+	stepLat := cell.Lat.Max - cell.Lat.Min
+	stepLng := cell.Lon.Max - cell.Lon.Min
+
+	seen := map[string]bool{}
+	regions := []string{}
+	add := func(lat, lng float64) {
+		region := geohash.Encode(math.Max(-90, math.Min(90, lat)), lng, regionPrecision)
+		if !seen[region] {
+			seen[region] = true
+			regions = append(regions, region)
+		}
+	}
+
+	for lat := center.Lat - dLat; ; lat = math.Min(lat+stepLat, center.Lat+dLat) {
+		for lng := center.Long - dLng; ; lng = math.Min(lng+stepLng, center.Long+dLng) {
+			add(lat, lng)
+			if lng >= center.Long+dLng {
+				break
+			}
+		}
+		if lat >= center.Lat+dLat {
+			break
+		}
+	}
+
+	return regions
+}
+
+// masterForKey returns the master owning the hash slot of the key.
+var masterForKey = func(key string) (*redis.Client, error) {
+	return rdb.MasterForKey(ctx, key)
+}
+
+// shardsForRegions returns the distinct masters owning the hash slots of the regions.
+func shardsForRegions(regions []string) ([]*redis.Client, error) {
+	seen := map[string]bool{}
+	shards := []*redis.Client{}
+	for _, region := range regions {
+		shard, err := masterForKey(driverKey(region, 0))
+		if err != nil {
+			return nil, err
+		}
+		addr := shard.Options().Addr
+		if !seen[addr] {
+			seen[addr] = true
+			shards = append(shards, shard)
+		}
+	}
+	return shards, nil
+}
+
+func allShards() ([]*redis.Client, error) {
+	var mt sync.Mutex
+	shards := []*redis.Client{}
+	err := rdb.ForEachMaster(ctx, func(ctx context.Context, shard *redis.Client) error {
+		mt.Lock()
+		defer mt.Unlock()
+		shards = append(shards, shard)
+		return nil
+	})
+	return shards, err
+}
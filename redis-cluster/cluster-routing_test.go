@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/pierrre/geohash"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestRegionOf(t *testing.T) {
+	tests := []struct {
+		geoHash string
+		want    string
+	}{
+		{"txkg0b", "txk"},
+		{"txk", "txk"},
+		{"tx", "tx"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := regionOf(tt.geoHash); got != tt.want {
+			t.Errorf("regionOf(%q) = %q, want %q", tt.geoHash, got, tt.want)
+		}
+	}
+}
+
+func TestRegionsInRadius(t *testing.T) {
+	// Tashkent lies inside one region cell, the tests place points relative to it
+	cell, _ := geohash.Decode(geohash.Encode(41.3, 69.24, regionPrecision))
+	midLat := (cell.Lat.Min + cell.Lat.Max) / 2
+	midLng := (cell.Lon.Min + cell.Lon.Max) / 2
+	home := geohash.Encode(midLat, midLng, regionPrecision)
+	north := geohash.Encode(cell.Lat.Max+0.01, midLng, regionPrecision)
+	east := geohash.Encode(midLat, cell.Lon.Max+0.01, regionPrecision)
+	northEast := geohash.Encode(cell.Lat.Max+0.01, cell.Lon.Max+0.01, regionPrecision)
+
+	tests := []struct {
+		name     string
+		center   Location
+		radiusKm float64
+		want     []string // exact result, nil to only check contains
+		contains []string
+		minCount int
+	}{
+		{"zero radius", Location{midLat, midLng}, 0, []string{home}, nil, 1},
+		{"inside one cell", Location{midLat, midLng}, 5, []string{home}, nil, 1},
+		{"crosses north border", Location{cell.Lat.Max - 0.01, midLng}, 5, nil, []string{home, north}, 2},
+		{"crosses east border", Location{midLat, cell.Lon.Max - 0.01}, 5, nil, []string{home, east}, 2},
+		{"crosses corner", Location{cell.Lat.Max - 0.01, cell.Lon.Max - 0.01}, 5, nil, []string{home, north, east, northEast}, 4},
+		{"spans several cells", Location{midLat, midLng}, 300, nil, []string{home, north, east, northEast}, 9},
+		{"near the pole", Location{89.95, 10}, 50, nil, []string{geohash.Encode(89.95, 10, regionPrecision)}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := regionsInRadius(tt.center, tt.radiusKm)
+			if tt.want != nil && !slices.Equal(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for _, region := range tt.contains {
+				if !slices.Contains(got, region) {
+					t.Errorf("%v does not contain %q", got, region)
+				}
+			}
+			if len(got) < tt.minCount {
+				t.Errorf("got %d regions, want at least %d", len(got), tt.minCount)
+			}
+			seen := map[string]bool{}
+			for _, region := range got {
+				if len(region) != regionPrecision {
+					t.Errorf("region %q has length %d", region, len(region))
+				}
+				if seen[region] {
+					t.Errorf("region %q returned twice", region)
+				}
+				seen[region] = true
+			}
+		})
+	}
+}
+
+func TestShardsForRegions(t *testing.T) {
+	a := redis.NewClient(&redis.Options{Addr: "a:7000"})
+	b := redis.NewClient(&redis.Options{Addr: "b:7001"})
+	owners := map[string]*redis.Client{
+		driverKey("txk", 0): a,
+		driverKey("txm", 0): b,
+		driverKey("txs", 0): a,
+	}
+	errLookup := errors.New("slot lookup failed")
+
+	saved := masterForKey
+	t.Cleanup(func() { masterForKey = saved })
+	masterForKey = func(key string) (*redis.Client, error) {
+		if shard, ok := owners[key]; ok {
+			return shard, nil
+		}
+		return nil, errLookup
+	}
+
+	tests := []struct {
+		name    string
+		regions []string
+		want    []string
+		wantErr error
+	}{
+		{"none", nil, []string{}, nil},
+		{"one", []string{"txm"}, []string{"b:7001"}, nil},
+		{"same shard once", []string{"txk", "txs"}, []string{"a:7000"}, nil},
+		{"first seen order", []string{"txm", "txk", "txs"}, []string{"b:7001", "a:7000"}, nil},
+		{"lookup error", []string{"txk", "zzz"}, nil, errLookup},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shards, err := shardsForRegions(tt.regions)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			got := []string{}
+			for _, shard := range shards {
+				got = append(got, shard.Options().Addr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
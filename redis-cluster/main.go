@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	rdb              *redis.ClusterClient
+	clusterAddrs           = []string{"localhost:7000", "localhost:7001", "localhost:7002", "localhost:7003", "localhost:7004", "localhost:7005"}
+	ctx                    = context.Background()
+	lastDriverId     int64 = 0
+	lastReadDriverId int64 = 0
+	mu                     = &sync.Mutex{}
+)
+
+const indexName = "index"
+
+const (
+	testCycleCount              = 1
+	writeGoroutinesCount        = 3
+	readGoroutinesCount         = 35
+	numDrivers                  = 1_000_000
+	regionPrecision             = 3
+	regionMoveRetries           = 5 // rewrites of a driver whose region changed concurrently
+	writeOpsPerMinute           = 1_000_000
+	singleGetOpsPerMinute       = 1_000_000
+	multiGetRadOpsPerMinute     = 1_500_000
+	multiGetGeoHashOpsPerMinute = 500_000
+)
+
+func main() {
+	// Connect to Redis Cluster
+	rdb = redis.NewClusterClient(&redis.ClusterOptions{
+		Addrs: clusterAddrs,
+		// FT.SEARCH replies are parsed in their RESP2 array form
+		Protocol: 2,
+	})
+
+	if err := waitForCluster(time.Minute); err != nil {
+		log.Fatal("Redis cluster connection error:", err)
+	}
+
+	fmt.Println("Creating per-shard indexes...")
+	if err := createShardIndexes(); err != nil {
+		log.Fatalf("Failed to create index: %v", err)
+	}
+
+	var (
+		totalWriteOperations int
+		totalWriteErrors     int
+		totalReadOperations  int
+		totalReadErrors      int
+	)
+	wg := &sync.WaitGroup{}
+	mt := &sync.Mutex{}
+	wg.Add(4)
+	go func(w *sync.WaitGroup) {
+		defer w.Done()
+		totalWriteOperations, totalWriteErrors = ConcurrentUpdates()
+	}(wg)
+	time.Sleep(time.Second * 20)
+	go func(w *sync.WaitGroup) {
+		defer w.Done()
+		ops, errCount := ConcurrentSingleGets()
+		fmt.Println("ConcurrentSingleGets Operation count - ", ops)
+		mt.Lock()
+		defer mt.Unlock()
+		totalReadOperations += ops
+		totalReadErrors += errCount
+	}(wg)
+	go func(w *sync.WaitGroup) {
+		defer w.Done()
+		ops, errCount := ConcurrentListGetInRaius()
+		fmt.Println("ConcurrentListGetInRaius Operation count - ", ops)
+		mt.Lock()
+		defer mt.Unlock()
+		totalReadOperations += ops
+		totalReadErrors += errCount
+	}(wg)
+	go func(w *sync.WaitGroup) {
+		defer w.Done()
+		ops, errCount := ConcurrentListInGeoHash()
+		fmt.Println("ConcurrentListInGeoHash Operation count - ", ops)
+		mt.Lock()
+		defer mt.Unlock()
+		totalReadOperations += ops
+		totalReadErrors += errCount
+	}(wg)
+
+	wg.Wait()
+
+	fmt.Println("\n|===== Summary of Write operations =====|")
+	fmt.Printf("Total Write Operations: %d\n", totalWriteOperations)
+	fmt.Printf("Total Write Operations per minute: %d\n", totalWriteOperations/testCycleCount)
+	fmt.Printf("Total Write Errors: %d\n", totalWriteErrors)
+	fmt.Println("\n|===== Summary of Read operations =====|")
+	fmt.Printf("Total Read Operations: %d\n", totalReadOperations)
+	fmt.Printf("Total Read Operations per minute: %d\n", totalReadOperations/testCycleCount)
+	fmt.Printf("Total Read Errors: %d\n", totalReadErrors)
+}
+
+// waitForCluster blocks until every master reports cluster_state:ok.
+func waitForCluster(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		err := rdb.ForEachMaster(ctx, func(ctx context.Context, shard *redis.Client) error {
+			info, err := shard.ClusterInfo(ctx).Result()
+			if err != nil {
+				return err
+			}
+			if !strings.Contains(info, "cluster_state:ok") {
+				return fmt.Errorf("%s: cluster state is not ok", shard.Options().Addr)
+			}
+			return nil
+		})
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(time.Second)
+		rdb.ReloadState(ctx)
+	}
+}
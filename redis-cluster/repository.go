@@ -0,0 +1,385 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type Location struct {
+	Lat  float64
+	Long float64
+}
+
+var ActiveTariffs []string = []string{"start", "camfort|camfort+", "business"}
+
+type Driver struct {
+	Id              int64
+	GeoHash         string
+	Location        Location
+	ActiveTariffs   []string
+	Score           int64
+	Charge          int64
+	Active          bool
+	LastUpdatedTime string
+}
+
+// Driver hashes carry their region as hash tag (driver:{<region>}:123), so every
+// driver of a region lands on the same shard. driver_region:<id> remembers the
+// current region, because the key changes when a driver crosses a region border.
+func driverKey(region string, id int64) string {
+	return fmt.Sprintf("driver:{%s}:%d", region, id)
+}
+
+func driverRegionKey(id int64) string {
+	return fmt.Sprintf("driver_region:%d", id)
+}
+
+// regionSwapScript moves driver_region:<id> from ARGV[1] ("" for a new driver)
+// to ARGV[2]. It replies 1, or 0 when another writer changed the region since
+// it was read.
+var regionSwapScript = redis.NewScript(`
+if (redis.call('GET', KEYS[1]) or '') ~= ARGV[1] then
+	return 0
+end
+redis.call('SET', KEYS[1], ARGV[2])
+return 1
+`)
+
+// Create driver if not exists if exists update it. The region pointer only
+// moves if nobody else moved it since it was read, drivers that lost such a
+// race are written again, so a driver never stays on two shards.
+func UpsertDrivers(drivers []Driver) error {
+	pending := drivers
+	for attempt := 0; len(pending) > 0; attempt++ {
+		if attempt == regionMoveRetries {
+			return fmt.Errorf("%d drivers changed region concurrently %d times in a row", len(pending), attempt)
+		}
+		var err error
+		if pending, err = upsertDriversOnce(pending); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// upsertDriversOnce writes the drivers and returns the ones whose region pointer
+// was changed concurrently.
+func upsertDriversOnce(drivers []Driver) ([]Driver, error) {
+	// Read the current region and write the new copy, go-redis splits the
+	// pipeline into one round trip per shard
+	pipe := rdb.Pipeline()
+	previous := make([]*redis.StringCmd, len(drivers))
+	for i, in := range drivers {
+		previous[i] = pipe.Get(ctx, driverRegionKey(in.Id))
+
+		fields := map[string]interface{}{
+			"driver_id": in.Id,
+			// RediSearch GEO fields expect "lon,lat"
+			"location":             fmt.Sprintf("%f,%f", in.Location.Long, in.Location.Lat),
+			"geo_hash":             in.GeoHash,
+			"active_tariffs":       strings.Join(in.ActiveTariffs, "|"),
+			"score":                in.Score,
+			"active":               fmt.Sprintf("%t", in.Active),
+			"phone_charge_percent": in.Charge,
+			"last_updated_time":    in.LastUpdatedTime,
+		}
+		pipe.HSet(ctx, driverKey(regionOf(in.GeoHash), in.Id), fields)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	// Move the region pointers, each one only if it is still what was read
+	swap := rdb.Pipeline()
+	swaps := make([]*redis.Cmd, len(drivers))
+	for i, in := range drivers {
+		old, _ := previous[i].Result()
+		swaps[i] = regionSwapScript.Eval(ctx, swap, []string{driverRegionKey(in.Id)}, old, regionOf(in.GeoHash))
+	}
+	if _, err := swap.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	// The driver moved to another region, remove the copy on the old shard
+	cleanup := rdb.Pipeline()
+	lost := []Driver{}
+	for i, in := range drivers {
+		moved, err := swaps[i].Int64()
+		if err != nil {
+			return nil, err
+		}
+		if moved == 0 {
+			lost = append(lost, in)
+			continue
+		}
+		if old, _ := previous[i].Result(); old != "" && old != regionOf(in.GeoHash) {
+			cleanup.Del(ctx, driverKey(old, in.Id))
+		}
+	}
+	if cleanup.Len() > 0 {
+		if _, err := cleanup.Exec(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return lost, nil
+}
+
+func GetDriver(id int64) (Driver, error) {
+	region, err := rdb.Get(ctx, driverRegionKey(id)).Result()
+	if err == redis.Nil {
+		return Driver{}, nil
+	}
+	if err != nil {
+		return Driver{}, err
+	}
+
+	// Get all fields from the hash
+	result, err := rdb.HGetAll(ctx, driverKey(region, id)).Result()
+	if err != nil {
+		return Driver{}, err
+	}
+
+	if len(result) == 0 {
+		return Driver{}, nil
+	}
+
+	return parseDriver(result), nil
+}
+
+// Parse hash fields into Driver struct
+func parseDriver(result map[string]string) Driver {
+	driver := Driver{}
+
+	if driverId, ok := result["driver_id"]; ok {
+		if id, err := strconv.ParseInt(driverId, 10, 64); err == nil {
+			driver.Id = id
+		}
+	}
+
+	if location, ok := result["location"]; ok {
+		parts := strings.Split(location, ",")
+		if len(parts) == 2 {
+			if lng, err := strconv.ParseFloat(parts[0], 64); err == nil {
+				driver.Location.Long = lng
+			}
+			if lat, err := strconv.ParseFloat(parts[1], 64); err == nil {
+				driver.Location.Lat = lat
+			}
+		}
+	}
+
+	if geoHash, ok := result["geo_hash"]; ok {
+		driver.GeoHash = geoHash
+	}
+
+	if activeTariffs, ok := result["active_tariffs"]; ok {
+		driver.ActiveTariffs = strings.Split(activeTariffs, "|")
+	}
+
+	if score, ok := result["score"]; ok {
+		if s, err := strconv.ParseInt(score, 10, 64); err == nil {
+			driver.Score = s
+		}
+	}
+
+	if active, ok := result["active"]; ok {
+		driver.Active = active == "true"
+	}
+
+	if charge, ok := result["phone_charge_percent"]; ok {
+		if c, err := strconv.ParseInt(charge, 10, 64); err == nil {
+			driver.Charge = c
+		}
+	}
+
+	if lastUpdated, ok := result["last_updated_time"]; ok {
+		driver.LastUpdatedTime = lastUpdated
+	}
+
+	return driver
+}
+
+// In response sort by driver_id field
+func GetDriverInRadius(location Location, radiusKm float64, limit int) ([]Driver, error) {
+	// Build the search query for geospatial search
+	query := fmt.Sprintf("@location:[%f %f %f km]", location.Long, location.Lat, radiusKm)
+
+	// Only the shards owning a region that overlaps the circle can have matches
+	shards, err := shardsForRegions(regionsInRadius(location, radiusKm))
+	if err != nil {
+		return nil, err
+	}
+
+	drivers, err := scatterSearch(shards, query, "driver_id", "ASC", limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return mergeDrivers(drivers, func(a, b Driver) bool { return a.Id < b.Id }, limit), nil
+}
+
+// In response sort by score field
+func GetDriverForOrder(geoHash string, tariffs []string, limit int) ([]Driver, error) {
+	// Build the search query
+	var queryParts []string
+
+	// Add geo_hash filter if provided
+	if geoHash != "" {
+		queryParts = append(queryParts, fmt.Sprintf("@geo_hash:%s*", geoHash))
+	}
+
+	// Add active filter
+	queryParts = append(queryParts, "@active:{true}")
+	queryParts = append(queryParts, fmt.Sprintf("@active_tariffs:{%s}", strings.Join(tariffs, "|")))
+
+	query := strings.Join(queryParts, " ")
+
+	// A prefix at least as long as a region pins the query to one shard,
+	// shorter or missing prefixes have to ask every shard
+	var shards []*redis.Client
+	var err error
+	if len(geoHash) >= regionPrecision {
+		shards, err = shardsForRegions([]string{regionOf(geoHash)})
+	} else {
+		shards, err = allShards()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	drivers, err := scatterSearch(shards, query, "score", "DESC", limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return mergeDrivers(drivers, func(a, b Driver) bool { return a.Score > b.Score }, limit), nil
+}
+
+// scatterSearch runs the query against the index of every shard in parallel and
+// returns the union of the results. Each shard applies SORTBY and LIMIT itself,
+// so the caller only has to merge and cut the combined list.
+func scatterSearch(shards []*redis.Client, query, sortBy, order string, limit int) ([]Driver, error) {
+	var (
+		wg       sync.WaitGroup
+		mt       sync.Mutex
+		drivers  []Driver
+		firstErr error
+	)
+
+	for _, shard := range shards {
+		wg.Add(1)
+		go func(shard *redis.Client) {
+			defer wg.Done()
+			found, err := searchShard(shard, query, sortBy, order, limit)
+
+			mt.Lock()
+			defer mt.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("%s: %w", shard.Options().Addr, err)
+				}
+				return
+			}
+			drivers = append(drivers, found...)
+		}(shard)
+	}
+	wg.Wait()
+
+	return drivers, firstErr
+}
+
+func searchShard(shard *redis.Client, query, sortBy, order string, limit int) ([]Driver, error) {
+	searchResult, err := shard.Do(ctx, "FT.SEARCH", indexName, query, "SORTBY", sortBy, order, "LIMIT", 0, limit).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse the search result
+	results, ok := searchResult.([]interface{})
+	if !ok || len(results) < 2 {
+		return []Driver{}, nil
+	}
+
+	// Skip the first element (total count), the rest are key / field list pairs
+	driverData := results[1:]
+	drivers := make([]Driver, 0, len(driverData)/2)
+
+	for i := 0; i+1 < len(driverData); i += 2 {
+		fieldList, ok := driverData[i+1].([]interface{})
+		if !ok {
+			continue
+		}
+
+		fields := make(map[string]string, len(fieldList)/2)
+		for j := 0; j+1 < len(fieldList); j += 2 {
+			fields[fmt.Sprint(fieldList[j])] = fmt.Sprint(fieldList[j+1])
+		}
+
+		drivers = append(drivers, parseDriver(fields))
+	}
+
+	return drivers, nil
+}
+
+// mergeDrivers combines the results of several shards: a driver found on two
+// shards while it moves between regions is kept once, with its newest update,
+// then the drivers are sorted by less and cut to the limit.
+func mergeDrivers(drivers []Driver, less func(a, b Driver) bool, limit int) []Driver {
+	index := make(map[int64]int, len(drivers))
+	merged := make([]Driver, 0, len(drivers))
+	for _, d := range drivers {
+		i, seen := index[d.Id]
+		if !seen {
+			index[d.Id] = len(merged)
+			merged = append(merged, d)
+			continue
+		}
+		if newerUpdate(d.LastUpdatedTime, merged[i].LastUpdatedTime) {
+			merged[i] = d
+		}
+	}
+
+	sort.SliceStable(merged, func(i, j int) bool { return less(merged[i], merged[j]) })
+	return truncate(merged, limit)
+}
+
+// newerUpdate compares two last_updated_time values in unix milliseconds.
+func newerUpdate(a, b string) bool {
+	ta, errA := strconv.ParseInt(a, 10, 64)
+	tb, errB := strconv.ParseInt(b, 10, 64)
+	if errA != nil || errB != nil {
+		return a > b
+	}
+	return ta > tb
+}
+
+func truncate(drivers []Driver, limit int) []Driver {
+	if len(drivers) > limit {
+		return drivers[:limit]
+	}
+	return drivers
+}
+
+// createShardIndexes creates the driver index on every master, each one covers
+// the drivers stored on its own shard only.
+func createShardIndexes() error {
+	return rdb.ForEachMaster(ctx, func(ctx context.Context, shard *redis.Client) error {
+		indexes, err := shard.Do(ctx, "FT._LIST").StringSlice()
+		if err != nil {
+			return err
+		}
+		for _, name := range indexes {
+			if name == indexName {
+				return nil
+			}
+		}
+
+		return shard.Do(ctx, createIndexArgs(indexName, driverSchema)...).Err()
+	})
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestMergeDrivers(t *testing.T) {
+	byID := func(a, b Driver) bool { return a.Id < b.Id }
+	byScore := func(a, b Driver) bool { return a.Score > b.Score }
+	d := func(id, score int64, updated string) Driver {
+		return Driver{Id: id, Score: score, LastUpdatedTime: updated}
+	}
+
+	tests := []struct {
+		name    string
+		drivers []Driver
+		less    func(a, b Driver) bool
+		limit   int
+		want    []Driver
+	}{
+		{"empty", nil, byID, 5, []Driver{}},
+		{"sorted by id", []Driver{d(3, 0, "1"), d(1, 0, "1"), d(2, 0, "1")}, byID, 5,
+			[]Driver{d(1, 0, "1"), d(2, 0, "1"), d(3, 0, "1")}},
+		{"sorted by score", []Driver{d(1, 10, "1"), d(2, 30, "1"), d(3, 20, "1")}, byScore, 5,
+			[]Driver{d(2, 30, "1"), d(3, 20, "1"), d(1, 10, "1")}},
+		{"cut to limit", []Driver{d(1, 10, "1"), d(2, 30, "1"), d(3, 20, "1")}, byScore, 2,
+			[]Driver{d(2, 30, "1"), d(3, 20, "1")}},
+		{"duplicate keeps newest", []Driver{d(1, 10, "1000"), d(1, 50, "2000")}, byScore, 5,
+			[]Driver{d(1, 50, "2000")}},
+		{"duplicate keeps newest when it comes first", []Driver{d(1, 50, "2000"), d(1, 10, "1000")}, byScore, 5,
+			[]Driver{d(1, 50, "2000")}},
+		{"duplicate compares numerically", []Driver{d(1, 10, "999"), d(1, 50, "1000")}, byScore, 5,
+			[]Driver{d(1, 50, "1000")}},
+		{"duplicate does not take a slot", []Driver{d(1, 30, "1"), d(1, 30, "2"), d(2, 20, "1")}, byScore, 2,
+			[]Driver{d(1, 30, "2"), d(2, 20, "1")}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := mergeDrivers(tt.drivers, tt.less, tt.limit)
+			if !slices.EqualFunc(got, tt.want, func(a, b Driver) bool {
+				return a.Id == b.Id && a.Score == b.Score && a.LastUpdatedTime == b.LastUpdatedTime
+			}) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
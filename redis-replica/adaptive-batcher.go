@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Write batch sizing modes of ConcurrentUpdates
+const (
+	batchFixed    = "fixed"    // writeBatchSize drivers, writeGoroutinesCount pipelines
+	batchAdaptive = "adaptive" // tuned by an AdaptiveBatcher
+)
+
+// AdaptiveBatcher tunes the write batch size and the number of concurrent
+// pipelines AIMD-style. While round trips stay under adaptiveTargetLatency
+// and throughput does not drop, the batch grows by adaptiveBatchStep, and once
+// it is at adaptiveMaxBatch another pipeline is added. On errors, slow round
+// trips or a throughput drop after growing, both are halved.
+type AdaptiveBatcher struct {
+	mu        sync.Mutex
+	batchSize int
+	pipelines int
+
+	windowStart   time.Time
+	windowOps     int
+	windowCalls   int
+	windowErrors  int
+	windowLatency time.Duration
+	grew          bool // the last adjustment increased the load
+	lastOpsPerSec float64
+
+	history []BatchSizeSample
+}
+
+// BatchSizeSample is one adjustment: what was observed over the last interval
+// and the settings chosen for the next one.
+type BatchSizeSample struct {
+	At          time.Time
+	MeanLatency time.Duration
+	OpsPerSec   float64
+	BatchSize   int
+	Pipelines   int
+}
+
+// writeBatcher is the batcher of the running ConcurrentUpdates, nil in fixed mode.
+var writeBatcher atomic.Pointer[AdaptiveBatcher]
+
+func NewAdaptiveBatcher() *AdaptiveBatcher {
+	return &AdaptiveBatcher{
+		batchSize:   writeBatchSize,
+		pipelines:   1,
+		windowStart: time.Now(),
+	}
+}
+
+// Current returns the batch size and number of pipelines to use now.
+func (b *AdaptiveBatcher) Current() (batchSize, pipelines int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.batchSize, b.pipelines
+}
+
+// Observe records one round trip and adjusts the settings every
+// adaptiveAdjustInterval.
+func (b *AdaptiveBatcher) Observe(ops int, latency time.Duration, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.windowCalls++
+	b.windowLatency += latency
+	if err != nil {
+		b.windowErrors++
+	} else {
+		b.windowOps += ops
+	}
+
+	if time.Since(b.windowStart) >= adaptiveAdjustInterval {
+		b.adjust()
+	}
+}
+
+func (b *AdaptiveBatcher) adjust() {
+	meanLatency := b.windowLatency / time.Duration(b.windowCalls)
+	opsPerSec := float64(b.windowOps) / time.Since(b.windowStart).Seconds()
+
+	congested := b.windowErrors > 0 || meanLatency > adaptiveTargetLatency ||
+		(b.grew && opsPerSec < b.lastOpsPerSec*(1-adaptiveThroughputDrop))
+	switch {
+	case congested:
+		b.batchSize = max(adaptiveMinBatch, b.batchSize/2)
+		b.pipelines = max(1, b.pipelines/2)
+		b.grew = false
+	case b.batchSize < adaptiveMaxBatch:
+		b.batchSize = min(adaptiveMaxBatch, b.batchSize+adaptiveBatchStep)
+		b.grew = true
+	case b.pipelines < adaptiveMaxPipelines:
+		b.pipelines++
+		b.grew = true
+	default:
+		b.grew = false
+	}
+
+	b.history = append(b.history, BatchSizeSample{
+		At:          time.Now(),
+		MeanLatency: meanLatency,
+		OpsPerSec:   opsPerSec,
+		BatchSize:   b.batchSize,
+		Pipelines:   b.pipelines,
+	})
+	b.lastOpsPerSec = opsPerSec
+	b.resetWindowLocked()
+}
+
+// ResetWindow starts a new observation interval, e.g. after a pause between
+// test cycles that would otherwise look like a throughput drop.
+func (b *AdaptiveBatcher) ResetWindow() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.resetWindowLocked()
+}
+
+func (b *AdaptiveBatcher) resetWindowLocked() {
+	b.windowStart = time.Now()
+	b.windowOps, b.windowCalls, b.windowErrors, b.windowLatency = 0, 0, 0, 0
+}
+
+func (b *AdaptiveBatcher) History() []BatchSizeSample {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]BatchSizeSample(nil), b.history...)
+}
+
+// concurrentAdaptiveUpdates is ConcurrentUpdates with the batch size and the
+// number of pipelines chosen by an AdaptiveBatcher. adaptiveMaxPipelines
+// workers are started, those beyond the current number of pipelines wait.
+func concurrentAdaptiveUpdates() LoadResult {
+	batcher := NewAdaptiveBatcher()
+	writeBatcher.Store(batcher)
+
+	var wg sync.WaitGroup
+	statsChan := make(chan Stats, adaptiveMaxPipelines*testCycleCount)
+
+	for cycle := range testCycleCount {
+		fmt.Printf("Starting adaptive Create/Update test cycle %d/%d\n", cycle+1, testCycleCount)
+		batcher.ResetWindow()
+		var written atomic.Int64
+
+		for i := range adaptiveMaxPipelines {
+			wg.Add(1)
+			go func(workerID, cycleID int) {
+				defer wg.Done()
+
+				startTime := time.Now()
+				operationCount := 0
+				errorCount := 0
+				latencies := []time.Duration{}
+
+				for time.Since(startTime) < time.Minute && written.Load() < writeOpsPerMinute {
+					batchSize, pipelines := batcher.Current()
+					if workerID >= pipelines {
+						time.Sleep(time.Millisecond * 10)
+						continue
+					}
+
+					batch := planWriteBatch(batchSize)
+					callStart := time.Now()
+					err := batch.run()
+					latency := time.Since(callStart)
+					latencies = append(latencies, latency)
+					batcher.Observe(batch.Updates, latency, err)
+					if err != nil {
+						errorCount += batch.Updates
+						log.Printf("Worker %d: Error writing %s batch %v", workerID, batch.Kind, err)
+					} else {
+						operationCount += batch.Updates
+						written.Add(int64(batch.Updates))
+					}
+				}
+
+				statsChan <- Stats{
+					WorkerID:   workerID,
+					CycleID:    cycleID,
+					Operations: operationCount,
+					Errors:     errorCount,
+					Duration:   time.Since(startTime),
+					Latencies:  latencies,
+				}
+			}(i, cycle)
+		}
+
+		wg.Wait()
+		time.Sleep(time.Second * 2)
+	}
+
+	close(statsChan)
+	return analyzeUpdateStats(statsChan, writeOpsPerMinute)
+}
+
+// printWriteBatching shows the batch sizes chosen over time, at most one line
+// per adaptiveReportInterval.
+func printWriteBatching() {
+	fmt.Println("\n|===== Write batching =====|")
+	batcher := writeBatcher.Load()
+	if batcher == nil {
+		fmt.Printf("Fixed batches of %d drivers, %d pipelines\n", writeBatchSize, writeGoroutinesCount)
+		return
+	}
+
+	history := batcher.History()
+	if len(history) == 0 {
+		fmt.Println("Adaptive, no adjustments made")
+		return
+	}
+	start := history[0].At.Add(-adaptiveAdjustInterval)
+	var lastPrinted time.Time
+	for i, s := range history {
+		if i != len(history)-1 && s.At.Sub(lastPrinted) < adaptiveReportInterval {
+			continue
+		}
+		lastPrinted = s.At
+		fmt.Printf("%-6v batch %-5d pipelines %-3d (observed: mean latency %v, %.0f ops/s)\n",
+			s.At.Sub(start).Round(time.Second), s.BatchSize, s.Pipelines, s.MeanLatency.Round(time.Microsecond), s.OpsPerSec)
+	}
+}
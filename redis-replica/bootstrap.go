@@ -0,0 +1,189 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Bootstrap waits until the master and every replica accept connections (asking
+// the sentinels or the master for the replica addresses), creates
+// the latest driver index version behind the indexAlias alias if it is missing and
+// verifies that the master and all replicas report the schema of the version the
+// alias points to.
+func Bootstrap(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+
+	if useSentinel {
+		master, replicaList, err := waitForSentinel(deadline)
+		if err != nil {
+			return fmt.Errorf("discovering topology from sentinels: %w", err)
+		}
+		masterAddr, replicaAddrs = master, replicaList
+	}
+
+	if err := waitForRedis(masterAddr, deadline); err != nil {
+		return err
+	}
+	rdbMaster = newMasterClient()
+
+	if !useSentinel {
+		found, err := waitForMasterReplicas(deadline)
+		if err != nil {
+			return fmt.Errorf("discovering replicas from master: %w", err)
+		}
+		replicaAddrs = found
+	}
+	for _, addr := range replicaAddrs {
+		if err := waitForRedis(addr, deadline); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	replicas, err = NewLoadBalancer(replicaAddrs)
+	if err != nil {
+		return fmt.Errorf("connecting to replicas: %w", err)
+	}
+
+	legacy, err := indexExists(rdbMaster, indexAlias)
+	if err != nil {
+		return fmt.Errorf("listing indexes on master: %w", err)
+	}
+	if legacy {
+		return fmt.Errorf("found unversioned index '%s', drop it with FT.DROPINDEX %s so the name can be used as alias", indexAlias, indexAlias)
+	}
+
+	current, err := resolveAlias(rdbMaster)
+	if err == nil {
+		if _, err := parseIndexVersion(current); err != nil {
+			// The alias serves the other storage mode, move it to this one
+			fmt.Printf("[Bootstrap] Alias '%s' points to %s, switching to %s storage...\n", indexAlias, current, storageMode)
+			current = ""
+		}
+	}
+	if current == "" {
+		latest := latestIndexVersion()
+		current = versionedIndexName(latest.Version)
+		if err := createVersionedIndex(latest); err != nil {
+			return err
+		}
+	}
+
+	version, err := parseIndexVersion(current)
+	if err != nil {
+		return err
+	}
+	expected, ok := findIndexVersion(version)
+	if !ok {
+		return fmt.Errorf("alias '%s' points to %s, which has no schema definition", indexAlias, current)
+	}
+	fmt.Printf("[Bootstrap] Alias '%s' points to %s, verifying schema...\n", indexAlias, current)
+	if latest := latestIndexVersion(); latest.Version != version {
+		fmt.Printf("[Bootstrap] Schema v%d is available, run `go run . migrate` to upgrade.\n", latest.Version)
+	}
+
+	mismatches := []string{}
+	for _, client := range append([]*redis.Client{rdbMaster}, replicas.Clients()...) {
+		diffs, err := verifyIndexSchema(client, current, storageSchema(expected.Schema), deadline)
+		if err != nil {
+			return err
+		}
+		mismatches = append(mismatches, prefixed(client.Options().Addr, diffs)...)
+	}
+
+	if len(mismatches) > 0 {
+		return errors.New("index schema mismatch:\n  " + strings.Join(mismatches, "\n  "))
+	}
+
+	setActiveSchema(expected.Schema)
+	fmt.Printf("[Bootstrap] Master and %d replicas report the expected schema.\n", len(replicas.Clients()))
+	return nil
+}
+
+// createVersionedIndex creates index_vN unless it already exists and points the alias to it.
+func createVersionedIndex(v IndexVersion) error {
+	name := versionedIndexName(v.Version)
+	exists, err := indexExists(rdbMaster, name)
+	if err != nil {
+		return fmt.Errorf("listing indexes on master: %w", err)
+	}
+	if !exists {
+		fmt.Printf("[Bootstrap] Creating index '%s'...\n", name)
+		if err := rdbMaster.Do(ctx, createIndexArgs(name, v.Schema)...).Err(); err != nil {
+			return fmt.Errorf("creating index %s: %w", name, err)
+		}
+	}
+	fmt.Printf("[Bootstrap] Pointing alias '%s' to %s...\n", indexAlias, name)
+	if err := rdbMaster.Do(ctx, "FT.ALIASUPDATE", indexAlias, name).Err(); err != nil {
+		return fmt.Errorf("adding alias '%s': %w", indexAlias, err)
+	}
+	return nil
+}
+
+// waitForRedis pings addr until it answers or the deadline passes.
+func waitForRedis(addr string, deadline time.Time) error {
+	client := redis.NewClient(&redis.Options{
+		Addr: addr,
+	})
+	defer client.Close()
+
+	fmt.Printf("[Bootstrap] Waiting for %s to accept connections", addr)
+	for {
+		err := client.Ping(ctx).Err()
+		if err == nil {
+			fmt.Println(" up!")
+			return nil
+		}
+		if time.Now().After(deadline) {
+			fmt.Println()
+			return fmt.Errorf("%s is not reachable: %w", addr, err)
+		}
+		fmt.Print(".")
+		time.Sleep(time.Second)
+	}
+}
+
+func indexExists(client *redis.Client, index string) (bool, error) {
+	indexes, err := client.Do(ctx, "FT._LIST").StringSlice()
+	if err != nil {
+		return false, err
+	}
+	return slices.Contains(indexes, index), nil
+}
+
+// verifyIndexSchema waits until the alias resolves to the index on the client
+// (replicas receive both through replication) and compares its attributes with
+// the expected schema.
+func verifyIndexSchema(client *redis.Client, index string, expected []SchemaField, deadline time.Time) ([]string, error) {
+	addr := client.Options().Addr
+	for {
+		info, err := ftInfo(client, indexAlias)
+		if err == nil && info["index_name"] == index {
+			schema, err := schemaFromInfo(info)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", addr, err)
+			}
+			return compareSchema(expected, schema), nil
+		}
+		if time.Now().After(deadline) {
+			if err == nil {
+				err = fmt.Errorf("alias resolves to %v", info["index_name"])
+			}
+			return nil, fmt.Errorf("%s: index '%s' not available through alias '%s': %w", addr, index, indexAlias, err)
+		}
+		time.Sleep(time.Millisecond * 500)
+	}
+}
+
+func prefixed(addr string, diffs []string) []string {
+	out := make([]string, 0, len(diffs))
+	for _, d := range diffs {
+		out = append(out, addr+": "+d)
+	}
+	return out
+}
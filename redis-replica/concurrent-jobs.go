@@ -1,13 +1,19 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"sync"
 	"time"
 )
 
-func ConcurrentUpdates() (int, int) {
+func ConcurrentUpdates() LoadResult {
+	if writeBatchMode == batchAdaptive {
+		return concurrentAdaptiveUpdates()
+	}
+	writeBatcher.Store(nil)
+
 	// fmt.Println("Starting concurrent updates test...")
 
 	// Create a wait group to wait for all goroutines to complete
@@ -31,23 +37,21 @@ func ConcurrentUpdates() (int, int) {
 				opsPerWorker := (writeOpsPerMinute / writeGoroutinesCount) + writeOpsPerMinute%writeGoroutinesCount
 				operationCount := 0
 				errorCount := 0
+				latencies := []time.Duration{}
 
 				for time.Since(startTime) < time.Minute {
 
-					// Update the driver in Redis
-					drivers := []Driver{}
-
-					for i := 0; i < 100; i++ {
-						driverID := getNextDriverId()
-						drivers = append(drivers, GenerateFakeDriver(driverID))
-					}
+					// Update drivers in Redis following the write mix, with some reordered and duplicated pings
+					batch := planWriteBatch(writeBatchSize)
 
-					err := UpsertDrivers(drivers)
+					callStart := time.Now()
+					err := batch.run()
+					latencies = append(latencies, time.Since(callStart))
 					if err != nil {
-						errorCount++
-						log.Printf("Worker %d: Error updating driver %v", workerID, err)
+						errorCount += batch.Updates
+						log.Printf("Worker %d: Error writing %s batch %v", workerID, batch.Kind, err)
 					} else {
-						operationCount += len(drivers)
+						operationCount += batch.Updates
 					}
 					if operationCount >= opsPerWorker {
 						break
@@ -61,6 +65,7 @@ func ConcurrentUpdates() (int, int) {
 					Operations: operationCount,
 					Errors:     errorCount,
 					Duration:   time.Since(startTime),
+					Latencies:  latencies,
 				}
 			}(i, cycle)
 		}
@@ -76,10 +81,10 @@ func ConcurrentUpdates() (int, int) {
 	close(statsChan)
 
 	// Collect and analyze statistics
-	return analyzeUpdateStats(statsChan)
+	return analyzeUpdateStats(statsChan, writeOpsPerMinute)
 }
 
-func ConcurrentSingleGets() (int, int) {
+func ConcurrentSingleGets() LoadResult {
 	// fmt.Println("Starting concurrent single GETs test...")
 
 	var wg sync.WaitGroup
@@ -97,15 +102,25 @@ func ConcurrentSingleGets() (int, int) {
 				opsPerWorker := (singleGetOpsPerMinute / readGoroutinesCount) + singleGetOpsPerMinute%readGoroutinesCount
 				operationCount := 0
 				errorCount := 0
+				notFoundCount := 0
+				malformedCount := 0
+				latencies := []time.Duration{}
 
 				for time.Since(startTime) < time.Minute {
 					driverID := getNextDriverIdRead()
+					callStart := time.Now()
 					_, err := GetDriver(driverID)
-					if err != nil {
+					latencies = append(latencies, time.Since(callStart))
+					switch {
+					case err == nil:
+						operationCount++
+					case errors.Is(err, ErrDriverNotFound):
+						notFoundCount++
+					case errors.Is(err, ErrMalformedDriver):
+						malformedCount++
+					default:
 						errorCount++
 						log.Printf("Worker %d: Error getting driver %d: %v", workerID, driverID, err)
-					} else {
-						operationCount++
 					}
 					if operationCount >= opsPerWorker {
 						break
@@ -117,7 +132,10 @@ func ConcurrentSingleGets() (int, int) {
 					CycleID:    cycleID,
 					Operations: operationCount,
 					Errors:     errorCount,
+					NotFound:   notFoundCount,
+					Malformed:  malformedCount,
 					Duration:   time.Since(startTime),
+					Latencies:  latencies,
 				}
 			}(i, cycle)
 		}
@@ -127,10 +145,10 @@ func ConcurrentSingleGets() (int, int) {
 	}
 
 	close(statsChan)
-	return analyzeUpdateStats(statsChan)
+	return analyzeUpdateStats(statsChan, singleGetOpsPerMinute)
 }
 
-func ConcurrentListGetInRaius() (int, int) {
+func ConcurrentListGetInRaius() LoadResult {
 	// fmt.Println("Starting concurrent list GETs in radius test...")
 
 	var wg sync.WaitGroup
@@ -149,15 +167,25 @@ func ConcurrentListGetInRaius() (int, int) {
 
 				operationCount := 0
 				errorCount := 0
+				notFoundCount := 0
+				malformedCount := 0
+				latencies := []time.Duration{}
 
 				for time.Since(startTime) < time.Minute {
 					lat, lng, _ := GetRandomLatLong()
-					_, err := GetDriverInRadius(Location{Lat: lat, Long: lng}, 5, 20) // 5km radius
-					if err != nil {
+					callStart := time.Now()
+					_, err := GetDriverInRadius(Location{Lat: lat, Long: lng}, 5, 20, DriverFilter{}) // 5km radius
+					latencies = append(latencies, time.Since(callStart))
+					switch {
+					case err == nil:
+						operationCount++
+					case errors.Is(err, ErrDriverNotFound):
+						notFoundCount++
+					case errors.Is(err, ErrMalformedDriver):
+						malformedCount++
+					default:
 						errorCount++
 						log.Printf("Worker %d: Error getting drivers in radius: %v", workerID, err)
-					} else {
-						operationCount++
 					}
 					if operationCount >= opsPerWorker {
 						break
@@ -169,7 +197,10 @@ func ConcurrentListGetInRaius() (int, int) {
 					CycleID:    cycleID,
 					Operations: operationCount,
 					Errors:     errorCount,
+					NotFound:   notFoundCount,
+					Malformed:  malformedCount,
 					Duration:   time.Since(startTime),
+					Latencies:  latencies,
 				}
 			}(i, cycle)
 		}
@@ -179,10 +210,10 @@ func ConcurrentListGetInRaius() (int, int) {
 	}
 
 	close(statsChan)
-	return analyzeUpdateStats(statsChan)
+	return analyzeUpdateStats(statsChan, multiGetRadOpsPerMinute)
 }
 
-func ConcurrentListInGeoHash() (int, int) {
+func ConcurrentListInGeoHash() LoadResult {
 	// fmt.Println("Starting concurrent list GETs in geohash test...")
 
 	var wg sync.WaitGroup
@@ -201,15 +232,25 @@ func ConcurrentListInGeoHash() (int, int) {
 
 				operationCount := 0
 				errorCount := 0
+				notFoundCount := 0
+				malformedCount := 0
+				latencies := []time.Duration{}
 
 				for time.Since(startTime) < time.Minute {
 					_, _, geohash := GetRandomLatLong()
-					_, err := GetDriverForOrder(geohash, GetRandomTariffs(), 5)
-					if err != nil {
+					callStart := time.Now()
+					_, err := GetDriverForOrder(geohash, GetRandomTariffs(), 5, DriverFilter{})
+					latencies = append(latencies, time.Since(callStart))
+					switch {
+					case err == nil:
+						operationCount++
+					case errors.Is(err, ErrDriverNotFound):
+						notFoundCount++
+					case errors.Is(err, ErrMalformedDriver):
+						malformedCount++
+					default:
 						errorCount++
 						log.Printf("Worker %d: Error getting drivers in geohash: %v", workerID, err)
-					} else {
-						operationCount++
 					}
 					if operationCount >= opsPerWorker {
 						break
@@ -221,7 +262,10 @@ func ConcurrentListInGeoHash() (int, int) {
 					CycleID:    cycleID,
 					Operations: operationCount,
 					Errors:     errorCount,
+					NotFound:   notFoundCount,
+					Malformed:  malformedCount,
 					Duration:   time.Since(startTime),
+					Latencies:  latencies,
 				}
 			}(i, cycle)
 		}
@@ -231,7 +275,7 @@ func ConcurrentListInGeoHash() (int, int) {
 	}
 
 	close(statsChan)
-	return analyzeUpdateStats(statsChan)
+	return analyzeUpdateStats(statsChan, multiGetGeoHashOpsPerMinute)
 }
 
 // UpdateStats represents statistics for update operations
@@ -239,19 +283,43 @@ type Stats struct {
 	WorkerID   int
 	CycleID    int
 	Operations int
-	Errors     int
+	Errors     int // operations in failed calls, a failed batch counts every update in it
+	NotFound   int // drivers that were not found
+	Malformed  int // drivers with malformed data
+	LostRaces  int // status transitions another writer got to first
 	Duration   time.Duration
+	Latencies  []time.Duration
+}
+
+// ConcurrentBatchGets looks up batchGetSize random drivers per call with
+// GetDrivers at batchGetOpsPerMinute drivers per minute.
+func ConcurrentBatchGets() LoadResult {
+	workloads, _ := findWorkloads("batch-get")
+	return runPacedLoad(workloads, batchGetOpsPerMinute, batchGetGoroutinesCount, time.Minute*testCycleCount)
+}
+
+// ConcurrentStatusTransitions moves random drivers one step through their
+// lifecycle at statusOpsPerMinute.
+func ConcurrentStatusTransitions() LoadResult {
+	workloads, _ := findWorkloads("status")
+	return runPacedLoad(workloads, statusOpsPerMinute, statusGoroutinesCount, time.Minute*testCycleCount)
 }
 
-func analyzeUpdateStats(statsChan <-chan Stats) (int, int) {
-	var totalOps, totalErrors int
+func analyzeUpdateStats(statsChan <-chan Stats, offeredOpsPerMinute int) LoadResult {
+	result := LoadResult{OfferedOpsPerMinute: offeredOpsPerMinute}
+	latencies := []time.Duration{}
 
 	for stats := range statsChan {
-		totalOps += stats.Operations
-		totalErrors += stats.Errors
+		result.Operations += stats.Operations
+		result.Errors += stats.Errors
+		result.NotFound += stats.NotFound
+		result.Malformed += stats.Malformed
+		latencies = append(latencies, stats.Latencies...)
 	}
 
-	return totalOps, totalErrors
+	result.AchievedOpsPerMinute = result.Operations / testCycleCount
+	result.Latency = summarizeLatencies(latencies)
+	return result
 }
 
 func getNextDriverId() int64 {
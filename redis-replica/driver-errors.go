@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrDriverNotFound  = errors.New("driver not found")
+	ErrMalformedDriver = errors.New("malformed driver data")
+)
+
+// Parse modes for stored drivers, see parseMode
+const (
+	parseStrict  = "strict"  // a malformed driver is an error and nothing is returned
+	parseLenient = "lenient" // fields that cannot be parsed stay zero, the driver is returned together with the error
+)
+
+// wholeDocument is the Field of a DriverParseError for a driver that could not
+// be decoded at all.
+const wholeDocument = "$"
+
+// DriverParseError is a stored driver field that could not be parsed. It
+// matches ErrMalformedDriver with errors.Is.
+type DriverParseError struct {
+	Key   string
+	Field string
+	Value string // the stored value, empty if unknown
+	Err   error
+}
+
+func (e *DriverParseError) Error() string {
+	switch {
+	case e.Field == wholeDocument:
+		return fmt.Sprintf("%s: cannot decode driver: %v", e.Key, e.Err)
+	case e.Value == "":
+		return fmt.Sprintf("%s: invalid %s: %v", e.Key, e.Field, e.Err)
+	}
+	return fmt.Sprintf("%s: invalid %s %q: %v", e.Key, e.Field, e.Value, e.Err)
+}
+
+func (e *DriverParseError) Unwrap() error {
+	return e.Err
+}
+
+func (e *DriverParseError) Is(target error) bool {
+	return target == ErrMalformedDriver
+}
+
+func driverNotFound(key string) error {
+	return fmt.Errorf("%w: %s", ErrDriverNotFound, key)
+}
+
+// usableDriver reports whether a driver returned with err can be used: there
+// was no error, or in lenient mode the driver was malformed but decoded.
+func usableDriver(err error) bool {
+	if err == nil {
+		return true
+	}
+	var parseErr *DriverParseError
+	return parseMode == parseLenient && errors.As(err, &parseErr) && parseErr.Field != wholeDocument
+}
+
+// usableResults reports whether a list of drivers returned with err can be
+// used: there was no error, or in lenient mode some drivers were malformed.
+func usableResults(err error) bool {
+	return err == nil || parseMode == parseLenient && errors.Is(err, ErrMalformedDriver)
+}
+
+// parsedDriver applies parseMode to the outcome of parsing a driver, errs are
+// the problems found in its fields.
+func parsedDriver(driver Driver, errs []error) (Driver, error) {
+	err := errors.Join(errs...)
+	if err != nil && parseMode == parseStrict {
+		return Driver{}, err
+	}
+	return driver, err
+}
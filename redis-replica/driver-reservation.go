@@ -0,0 +1,316 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var ErrNoDriverAvailable = errors.New("no candidate driver could be reserved")
+
+func driverHoldKey(id int64) string {
+	return "driver_hold:" + strconv.FormatInt(id, 10)
+}
+
+// Every hold is also recorded in this sorted set, scored by its expiry in unix
+// milliseconds, so the sweeper can free reserved drivers whose hold expired.
+const holdsKey = "driver_holds"
+
+// Owners of holds that do not belong to an order
+const (
+	holdOwnerSeed       = "seed"
+	holdOwnerTransition = "transition"
+)
+
+// reserveDriverScript walks the candidates in order and claims the first free
+// one: its status becomes reserved and a hold with the order id expires after
+// the hold time. A reserved driver whose hold expired counts as free again, the
+// sweeper frees such drivers but may not have run yet. KEYS are the holds set
+// followed by driver key / hold key pairs, ARGV the order id, the hold time in
+// milliseconds, the storage mode, the legacy active value for reserved, the hold
+// expiry and the candidate ids. It returns the index of the claimed candidate
+// (-1 if none) and how many candidates were skipped because another order held
+// them.
+var reserveDriverScript = redis.NewScript(luaStatusFunctions + `
+local held = 0
+for i = 2, #KEYS, 2 do
+	local candidate = (i - 2) / 2
+	local status = get_status(KEYS[i], ARGV[3])
+	if status == 'reserved' and redis.call('GET', KEYS[i + 1]) == ARGV[1] then
+		return {candidate, held}
+	end
+	if status == 'free' or (status == 'reserved' and redis.call('EXISTS', KEYS[i + 1]) == 0) then
+		redis.call('SET', KEYS[i + 1], ARGV[1], 'PX', ARGV[2])
+		redis.call('ZADD', KEYS[1], ARGV[5], ARGV[6 + candidate])
+		set_status(KEYS[i], ARGV[3], 'reserved', ARGV[4])
+		return {candidate, held}
+	end
+	if status == 'reserved' then
+		held = held + 1
+	end
+end
+return {-1, held}
+`)
+
+// releaseDriverScript frees the driver if the order still holds it. KEYS are
+// the hold, the driver key and the holds set, ARGV the order id, the storage
+// mode, the legacy active value for free and the driver id.
+var releaseDriverScript = redis.NewScript(luaStatusFunctions + `
+if redis.call('GET', KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+redis.call('DEL', KEYS[1])
+redis.call('ZREM', KEYS[3], ARGV[4])
+if get_status(KEYS[2], ARGV[2]) == 'reserved' then
+	set_status(KEYS[2], ARGV[2], 'free', ARGV[3])
+end
+return 1
+`)
+
+// expireHoldsScript frees reserved drivers whose hold expired. KEYS are the
+// holds set followed by driver key / hold key pairs, ARGV the current time in
+// unix milliseconds, the storage mode, the legacy active value for free and the
+// driver ids. A driver that was reserved again in the meantime has a newer
+// expiry or a live hold and is left alone. It returns how many drivers it freed
+// and how many entries it removed from the holds set.
+var expireHoldsScript = redis.NewScript(luaStatusFunctions + `
+local freed = 0
+local removed = 0
+for i = 2, #KEYS, 2 do
+	local id = ARGV[4 + (i - 2) / 2]
+	local expiry = redis.call('ZSCORE', KEYS[1], id)
+	if expiry and tonumber(expiry) <= tonumber(ARGV[1]) and redis.call('EXISTS', KEYS[i + 1]) == 0 then
+		if get_status(KEYS[i], ARGV[2]) == 'reserved' then
+			set_status(KEYS[i], ARGV[2], 'free', ARGV[3])
+			freed = freed + 1
+		end
+		redis.call('ZREM', KEYS[1], id)
+		removed = removed + 1
+	end
+end
+return {freed, removed}
+`)
+
+// ReserveDriver atomically claims the first free candidate, the hold expires
+// after reservationHoldTTL. Reserving again for the same order returns the
+// driver it already holds. conflicts is the number of candidates skipped
+// because another order held them.
+func ReserveDriver(orderID string, candidates []int64) (driverID int64, conflicts int, err error) {
+	if len(candidates) == 0 {
+		return 0, 0, ErrNoDriverAvailable
+	}
+
+	keys := make([]string, 0, len(candidates)*2+1)
+	keys = append(keys, holdsKey)
+	args := []interface{}{orderID, reservationHoldTTL.Milliseconds(), storageMode, legacyActive(StatusReserved), holdExpiry()}
+	for _, id := range candidates {
+		keys = append(keys, driverKey(id), driverHoldKey(id))
+		args = append(args, id)
+	}
+
+	reply, err := reserveDriverScript.Run(ctx, rdbMaster, keys, args...).Int64Slice()
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(reply) != 2 {
+		return 0, 0, fmt.Errorf("unexpected reply %v", reply)
+	}
+
+	conflicts = int(reply[1])
+	if reply[0] < 0 {
+		return 0, conflicts, ErrNoDriverAvailable
+	}
+	return candidates[reply[0]], conflicts, nil
+}
+
+// ReleaseDriver ends the order's hold and frees the driver, holds of other
+// orders are left alone.
+func ReleaseDriver(orderID string, driverID int64) error {
+	return releaseDriverScript.Run(ctx, rdbMaster, []string{driverHoldKey(driverID), driverKey(driverID), holdsKey},
+		orderID, storageMode, legacyActive(StatusFree), driverID).Err()
+}
+
+func holdExpiry() int64 {
+	return time.Now().Add(reservationHoldTTL).UnixMilli()
+}
+
+// resetHolds makes the holds match the statuses of freshly registered drivers:
+// reserved drivers get a hold that expires after reservationHoldTTL, all others
+// lose any hold they had.
+func resetHolds(drivers []Driver) error {
+	expiry := holdExpiry()
+	pipe := rdbMaster.Pipeline()
+	for _, d := range drivers {
+		if d.Status == StatusReserved {
+			pipe.Set(ctx, driverHoldKey(d.Id), holdOwnerSeed, reservationHoldTTL)
+			pipe.ZAdd(ctx, holdsKey, redis.Z{Score: float64(expiry), Member: d.Id})
+		} else {
+			pipe.Del(ctx, driverHoldKey(d.Id))
+			pipe.ZRem(ctx, holdsKey, d.Id)
+		}
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// expireHolds frees reserved drivers whose hold expired, in batches of
+// presenceSweepBatch, and returns how many it freed.
+func expireHolds() (int, error) {
+	freed := 0
+	for {
+		now := time.Now().UnixMilli()
+		ids, err := rdbMaster.ZRangeByScore(ctx, holdsKey, &redis.ZRangeBy{
+			Min:   "-inf",
+			Max:   strconv.FormatInt(now, 10),
+			Count: presenceSweepBatch,
+		}).Result()
+		if err != nil {
+			return freed, fmt.Errorf("reading expired holds: %w", err)
+		}
+		if len(ids) == 0 {
+			return freed, nil
+		}
+
+		keys := []string{holdsKey}
+		args := []interface{}{now, storageMode, legacyActive(StatusFree)}
+		for _, member := range ids {
+			id, err := strconv.ParseInt(member, 10, 64)
+			if err != nil {
+				continue
+			}
+			keys = append(keys, driverKey(id), driverHoldKey(id))
+			args = append(args, member)
+		}
+
+		reply, err := expireHoldsScript.Run(ctx, rdbMaster, keys, args...).Int64Slice()
+		if err != nil {
+			return freed, fmt.Errorf("expiring holds: %w", err)
+		}
+		if len(reply) != 2 {
+			return freed, fmt.Errorf("unexpected reply %v", reply)
+		}
+		freed += int(reply[0])
+
+		// Holds whose key outlives the recorded expiry stay for the next sweep
+		if len(ids) < presenceSweepBatch || reply[1] == 0 {
+			return freed, nil
+		}
+	}
+}
+
+type reservationResult struct {
+	Orders        int64
+	Reserved      int64
+	NoDriver      int64
+	Conflicts     int64
+	DoubleBooking int64
+	Errors        int64
+	Latency       LatencySummary
+}
+
+// runReservationBenchmark lets reservationGoroutinesCount dispatchers compete for
+// drivers in a few hot areas. Each one searches candidates, reserves one, keeps
+// it for reservationTripDuration and releases it. A driver claimed by a second
+// order while the first still holds it is counted as double booking.
+func runReservationBenchmark() error {
+	hotspots := make([]string, reservationHotspots)
+	for i := range hotspots {
+		_, _, geoHash := GetRandomLatLong()
+		hotspots[i] = geoHash[:reservationGeoHashPrecision]
+	}
+
+	var (
+		result    reservationResult
+		holders   sync.Map // driver id -> order id, while held
+		orderSeq  atomic.Int64
+		latencies = make([][]time.Duration, reservationGoroutinesCount)
+		wg        sync.WaitGroup
+	)
+
+	fmt.Printf("[Reserve] %d dispatchers competing for drivers in %d areas for %v...\n",
+		reservationGoroutinesCount, reservationHotspots, reservationDuration)
+	// Frees drivers whose hold expired, e.g. seeded reservations
+	sweeper := StartPresenceSweeper(presenceSweepInterval)
+	deadline := time.Now().Add(reservationDuration)
+	for g := 0; g < reservationGoroutinesCount; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for time.Now().Before(deadline) {
+				orderID := "order-" + strconv.FormatInt(orderSeq.Add(1), 10)
+				atomic.AddInt64(&result.Orders, 1)
+
+				found, err := GetDriverForOrder(hotspots[g%len(hotspots)], GetRandomTariffs(), reservationCandidates, DriverFilter{})
+				if !usableResults(err) {
+					atomic.AddInt64(&result.Errors, 1)
+					continue
+				}
+				candidates := make([]int64, 0, len(found))
+				for _, d := range found {
+					candidates = append(candidates, d.Id)
+				}
+
+				start := time.Now()
+				driverID, conflicts, err := ReserveDriver(orderID, candidates)
+				latencies[g] = append(latencies[g], time.Since(start))
+				atomic.AddInt64(&result.Conflicts, int64(conflicts))
+				if errors.Is(err, ErrNoDriverAvailable) {
+					atomic.AddInt64(&result.NoDriver, 1)
+					continue
+				}
+				if err != nil {
+					atomic.AddInt64(&result.Errors, 1)
+					continue
+				}
+				atomic.AddInt64(&result.Reserved, 1)
+
+				if other, loaded := holders.LoadOrStore(driverID, orderID); loaded {
+					atomic.AddInt64(&result.DoubleBooking, 1)
+					fmt.Printf("[Reserve] Driver %d reserved by %s while held by %s\n", driverID, orderID, other)
+					// Drop the second hold, otherwise it inflates the conflicts until it expires
+					if err := ReleaseDriver(orderID, driverID); err != nil {
+						atomic.AddInt64(&result.Errors, 1)
+					}
+					continue
+				}
+
+				time.Sleep(reservationTripDuration)
+
+				// Forget the holder first, Redis keeps the hold until the release
+				holders.Delete(driverID)
+				if err := ReleaseDriver(orderID, driverID); err != nil {
+					atomic.AddInt64(&result.Errors, 1)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	presence := sweeper.Stop()
+
+	all := []time.Duration{}
+	for _, l := range latencies {
+		all = append(all, l...)
+	}
+	result.Latency = summarizeLatencies(all)
+
+	printReservationReport(result)
+	printPresenceReport(presence)
+	if result.DoubleBooking > 0 {
+		return fmt.Errorf("%d drivers were booked twice", result.DoubleBooking)
+	}
+	return nil
+}
+
+func printReservationReport(r reservationResult) {
+	fmt.Println("\n|===== Driver reservation =====|")
+	fmt.Printf("Orders: %d, reserved %d, no driver available %d, errors %d\n", r.Orders, r.Reserved, r.NoDriver, r.Errors)
+	fmt.Printf("Conflicts (candidates held by another order): %d\n", r.Conflicts)
+	fmt.Printf("Double bookings: %d\n", r.DoubleBooking)
+	fmt.Printf("ReserveDriver latency: p50 %v, p99 %v, max %v\n",
+		r.Latency.P50.Round(time.Microsecond), r.Latency.P99.Round(time.Microsecond), r.Latency.Max.Round(time.Microsecond))
+}
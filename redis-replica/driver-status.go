@@ -0,0 +1,240 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// DriverStatus is the lifecycle state of a driver, indexed as the status TAG.
+type DriverStatus string
+
+const (
+	StatusOffline  DriverStatus = "offline"
+	StatusFree     DriverStatus = "free"
+	StatusReserved DriverStatus = "reserved"
+	StatusEnRoute  DriverStatus = "en_route"
+	StatusOnTrip   DriverStatus = "on_trip"
+)
+
+// driverTransitions lists the statuses a driver may move to from each status.
+// The transition script is generated from it, so this is the only definition.
+var driverTransitions = map[DriverStatus][]DriverStatus{
+	StatusOffline:  {StatusFree},
+	StatusFree:     {StatusOffline, StatusReserved},
+	StatusReserved: {StatusFree, StatusEnRoute},
+	StatusEnRoute:  {StatusOnTrip, StatusFree},
+	StatusOnTrip:   {StatusFree, StatusOffline},
+}
+
+var (
+	ErrInvalidTransition = errors.New("invalid driver status transition")
+	ErrStatusChanged     = errors.New("driver status changed concurrently")
+)
+
+func (s DriverStatus) CanTransitionTo(to DriverStatus) bool {
+	for _, next := range driverTransitions[s] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// legacyActive is the value of the old active field for the status, or "" when
+// the active schema has no active field. Indexes before v3 filter on it, so it
+// is kept in sync with the status while such an index is live.
+func legacyActive(status DriverStatus) string {
+	if activeFieldType("active") == "" {
+		return ""
+	}
+	return fmt.Sprint(encodeActive(status == StatusFree))
+}
+
+// luaStatusFunctions reads and writes the status of a driver hash or document.
+// active is the legacy active value, "" leaves the field alone.
+const luaStatusFunctions = `
+local function get_status(key, mode)
+	if mode == 'json' then
+		local raw = redis.call('JSON.GET', key, '$.status')
+		if not raw then
+			return nil
+		end
+		return cjson.decode(raw)[1]
+	end
+	local status = redis.call('HGET', key, 'status')
+	if not status then
+		return nil
+	end
+	return status
+end
+
+local function set_status(key, mode, status, active)
+	if mode == 'json' then
+		redis.call('JSON.SET', key, '$.status', cjson.encode(status))
+		if active ~= '' then
+			redis.call('JSON.SET', key, '$.active', active)
+		end
+	elseif active ~= '' then
+		redis.call('HSET', key, 'status', status, 'active', active)
+	else
+		redis.call('HSET', key, 'status', status)
+	end
+end
+`
+
+// transitionScript moves a driver to ARGV[2] if the transition is allowed and,
+// when ARGV[1] is set, the driver is still in that status. KEYS are the driver
+// key, its hold and the holds set, ARGV[3] the storage mode, ARGV[4] the legacy
+// active value, then the hold owner, the hold time in milliseconds, the hold
+// expiry and the driver id. Moving into reserved creates a hold, leaving it
+// drops the hold. It replies with the outcome and the status the driver had.
+var transitionScript = redis.NewScript(luaStatusFunctions + luaTransitionTable() + `
+local current = get_status(KEYS[1], ARGV[3])
+if not current then
+	return {'missing', ''}
+end
+if ARGV[1] ~= '' and current ~= ARGV[1] then
+	return {'changed', current}
+end
+if not (allowed[current] and allowed[current][ARGV[2]]) then
+	return {'invalid', current}
+end
+set_status(KEYS[1], ARGV[3], ARGV[2], ARGV[4])
+if ARGV[2] == 'reserved' then
+	redis.call('SET', KEYS[2], ARGV[5], 'PX', ARGV[6])
+	redis.call('ZADD', KEYS[3], ARGV[7], ARGV[8])
+elseif current == 'reserved' then
+	redis.call('DEL', KEYS[2])
+	redis.call('ZREM', KEYS[3], ARGV[8])
+end
+return {'ok', current}
+`)
+
+// luaTransitionTable renders driverTransitions as a Lua table of sets.
+func luaTransitionTable() string {
+	froms := make([]string, 0, len(driverTransitions))
+	for from := range driverTransitions {
+		froms = append(froms, string(from))
+	}
+	sort.Strings(froms)
+
+	var b strings.Builder
+	b.WriteString("local allowed = {\n")
+	for _, from := range froms {
+		fmt.Fprintf(&b, "\t['%s'] = {", from)
+		for _, to := range driverTransitions[DriverStatus(from)] {
+			fmt.Fprintf(&b, "['%s'] = true, ", to)
+		}
+		b.WriteString("},\n")
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// TransitionDriver atomically moves the driver to the target status. With a
+// non-empty from the driver must still be in that status, otherwise
+// ErrStatusChanged is returned. Transitions not in driverTransitions fail with
+// ErrInvalidTransition. A driver moved to reserved this way is held like a
+// reservation and freed by the sweeper when the hold expires.
+func TransitionDriver(id int64, from, to DriverStatus) error {
+	reply, err := transitionScript.Run(ctx, rdbMaster, []string{driverKey(id), driverHoldKey(id), holdsKey},
+		string(from), string(to), storageMode, legacyActive(to),
+		holdOwnerTransition, reservationHoldTTL.Milliseconds(), holdExpiry(), id).StringSlice()
+	if err != nil {
+		return err
+	}
+	if len(reply) != 2 {
+		return fmt.Errorf("unexpected reply %v", reply)
+	}
+
+	switch reply[0] {
+	case "ok":
+		return nil
+	case "missing":
+		return driverNotFound(driverKey(id))
+	case "changed":
+		return fmt.Errorf("%w: driver %d is %s, expected %s", ErrStatusChanged, id, reply[1], from)
+	case "invalid":
+		return fmt.Errorf("%w: driver %d %s -> %s", ErrInvalidTransition, id, reply[1], to)
+	}
+	return fmt.Errorf("unexpected reply %v", reply)
+}
+
+// statusShares is the share of the fleet in each status for generated drivers.
+var statusShares = []struct {
+	Status DriverStatus
+	Share  float64
+}{
+	{StatusOffline, 0.15},
+	{StatusFree, 0.60},
+	{StatusReserved, 0.03},
+	{StatusEnRoute, 0.07},
+	{StatusOnTrip, 0.15},
+}
+
+// parseStatus accepts only the statuses of driverTransitions.
+func parseStatus(value string) (DriverStatus, error) {
+	status := DriverStatus(value)
+	if _, ok := driverTransitions[status]; !ok {
+		return "", errors.New("unknown status")
+	}
+	return status, nil
+}
+
+func randomStatus() DriverStatus {
+	r := rand.Float64()
+	for _, s := range statusShares {
+		if r < s.Share {
+			return s.Status
+		}
+		r -= s.Share
+	}
+	return StatusFree
+}
+
+// nextStatus picks a plausible next status. Drivers mostly progress through a
+// trip, cancellations and going offline are less likely.
+func nextStatus(current DriverStatus) DriverStatus {
+	switch current {
+	case StatusOffline:
+		return StatusFree
+	case StatusFree:
+		if rand.Float64() < 0.1 {
+			return StatusOffline
+		}
+		return StatusReserved
+	case StatusReserved:
+		if rand.Float64() < 0.2 {
+			return StatusFree
+		}
+		return StatusEnRoute
+	case StatusEnRoute:
+		if rand.Float64() < 0.05 {
+			return StatusFree
+		}
+		return StatusOnTrip
+	case StatusOnTrip:
+		if rand.Float64() < 0.1 {
+			return StatusOffline
+		}
+		return StatusFree
+	}
+	return StatusFree
+}
+
+// advanceDriverStatus moves a random driver one step through its lifecycle.
+// The status is read from the master, a replica that lags behind would make
+// the transition fail. Losing a race against another writer returns
+// ErrStatusChanged, which the workload stats count on their own.
+func advanceDriverStatus(id int64) error {
+	driver, err := getDriver(rdbMaster, id)
+	if err != nil {
+		return err
+	}
+	return TransitionDriver(id, driver.Status, nextStatus(driver.Status))
+}
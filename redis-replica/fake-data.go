@@ -25,12 +25,8 @@ func GenerateFakeDriver(id int64) Driver {
 	// Generate random phone charge percentage (0-100)
 	charge := rand.Int63n(101)
 
-	// Randomly set active status (80% chance of being active)
-	active := rand.Float64() < 0.8
-
-	// Generate last updated time (within last 24 hours)
-	lastUpdated := time.Now().Add(-time.Duration(rand.Intn(24)) * time.Hour)
-	lastUpdatedTime := strconv.FormatInt(lastUpdated.Unix(), 10)
+	// Every generated driver is a ping that happens now
+	lastUpdatedTime := strconv.FormatInt(time.Now().UnixMilli(), 10)
 
 	return Driver{
 		Id:              id,
@@ -39,11 +35,20 @@ func GenerateFakeDriver(id int64) Driver {
 		ActiveTariffs:   GetRandomTariffs(),
 		Score:           score,
 		Charge:          charge,
-		Active:          active,
+		Status:          randomStatus(),
 		LastUpdatedTime: lastUpdatedTime,
 	}
 }
 
+// GenerateSeedDriver generates a driver whose last ping lies within the last
+// seedPingSpread, so part of the seeded fleet is already stale.
+func GenerateSeedDriver(id int64) Driver {
+	driver := GenerateFakeDriver(id)
+	lastUpdated := time.Now().Add(-time.Duration(rand.Int63n(int64(seedPingSpread))))
+	driver.LastUpdatedTime = strconv.FormatInt(lastUpdated.UnixMilli(), 10)
+	return driver
+}
+
 func GetRandomLatLong() (float64, float64, string) {
 	baseLat := 41.2995
 	baseLng := 69.2401
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// IndexVersion is one revision of the driver index schema. Each version is built
+// as its own index_vN and queries reach it through the indexAlias alias.
+type IndexVersion struct {
+	Version int
+	Schema  []SchemaField
+}
+
+// schemaVersions lists every schema revision, the last entry is the one new
+// deployments get and `migrate` moves existing ones to.
+var schemaVersions = []IndexVersion{
+	{Version: 1, Schema: driverSchema},
+	{Version: 2, Schema: driverSchemaV2},
+	{Version: 3, Schema: driverSchemaV3},
+}
+
+// v2 indexes battery and freshness so dispatch can filter on them
+var driverSchemaV2 = withFields(driverSchema,
+	SchemaField{Name: "phone_charge_percent", Type: "NUMERIC"},
+	SchemaField{Name: "last_updated_time", Type: "NUMERIC"},
+)
+
+// v3 replaces the active flag with the driver status
+var driverSchemaV3 = append(withoutFields(driverSchemaV2, "active"),
+	SchemaField{Name: "status", Type: "TAG"},
+)
+
+func latestIndexVersion() IndexVersion {
+	return schemaVersions[len(schemaVersions)-1]
+}
+
+func findIndexVersion(version int) (IndexVersion, bool) {
+	for _, v := range schemaVersions {
+		if v.Version == version {
+			return v, true
+		}
+	}
+	return IndexVersion{}, false
+}
+
+// versionedIndexPrefix keeps hash and JSON indexes apart: index_vN and index_json_vN.
+func versionedIndexPrefix() string {
+	if storageMode == storageJSON {
+		return indexAlias + "_json_v"
+	}
+	return indexAlias + "_v"
+}
+
+func versionedIndexName(version int) string {
+	return versionedIndexPrefix() + strconv.Itoa(version)
+}
+
+// parseIndexVersion extracts N from an index_vN name.
+func parseIndexVersion(name string) (int, error) {
+	suffix, ok := strings.CutPrefix(name, versionedIndexPrefix())
+	if !ok {
+		return 0, fmt.Errorf("index %q is not a versioned %s index for %s storage", name, indexAlias, storageMode)
+	}
+	return strconv.Atoi(suffix)
+}
+
+// resolveAlias returns the name of the index the alias currently points to.
+func resolveAlias(client *redis.Client) (string, error) {
+	info, err := ftInfo(client, indexAlias)
+	if err != nil {
+		return "", err
+	}
+	name, ok := info["index_name"].(string)
+	if !ok {
+		return "", fmt.Errorf("FT.INFO %s has no index_name", indexAlias)
+	}
+	return name, nil
+}
+
+// waitForIndexing blocks until the index is fully built on the client.
+func waitForIndexing(client *redis.Client, index string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		info, err := ftInfo(client, index)
+		if err == nil && infoInt(info, "indexing") == 0 && infoFloat(info, "percent_indexed") >= 1 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			if err != nil {
+				return fmt.Errorf("%s: index '%s' not available: %w", client.Options().Addr, index, err)
+			}
+			return fmt.Errorf("%s: index '%s' still indexing (%.0f%%) after %v",
+				client.Options().Addr, index, infoFloat(info, "percent_indexed")*100, timeout)
+		}
+		time.Sleep(time.Millisecond * 500)
+	}
+}
+
+// waitForAlias blocks until the alias resolves to the index on the client.
+func waitForAlias(client *redis.Client, index string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		current, err := resolveAlias(client)
+		if err == nil && current == index {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s: alias '%s' points to %q, expected %q", client.Options().Addr, indexAlias, current, index)
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+}
+
+// MigrateIndex builds the latest schema version next to the live one, waits until
+// the master and all replicas finished indexing it, swaps the alias and drops the
+// old index. While the alias moves, queries go to the new index by name with the
+// new schema, so they never hit an index that lacks the fields they filter on.
+func MigrateIndex() error {
+	current, err := resolveAlias(rdbMaster)
+	if err != nil {
+		return fmt.Errorf("resolving alias '%s': %w", indexAlias, err)
+	}
+	target := latestIndexVersion()
+	targetName := versionedIndexName(target.Version)
+	if current == targetName {
+		fmt.Printf("[Migrate] Alias '%s' already points to %s, nothing to do.\n", indexAlias, targetName)
+		return nil
+	}
+
+	startTime := time.Now()
+	fmt.Printf("[Migrate] Building %s while %s serves queries...\n", targetName, current)
+	if err := rdbMaster.Do(ctx, createIndexArgs(targetName, target.Schema)...).Err(); err != nil {
+		return fmt.Errorf("creating %s: %w", targetName, err)
+	}
+	for _, client := range append([]*redis.Client{rdbMaster}, replicas.Clients()...) {
+		if err := waitForIndexing(client, targetName, migrateIndexingTimeout); err != nil {
+			return err
+		}
+	}
+	buildTime := time.Since(startTime)
+	fmt.Printf("[Migrate] %s indexed on master and %d replicas in %v\n", targetName, len(replicas.Clients()), buildTime.Round(time.Millisecond))
+
+	// The target is indexed everywhere, so queries can move before the alias does
+	swapStart := time.Now()
+	previousSchema := activeSchema.Load()
+	pinnedIndex.Store(&targetName)
+	defer pinnedIndex.Store(nil)
+	setActiveSchema(target.Schema)
+	if err := rdbMaster.Do(ctx, "FT.ALIASUPDATE", indexAlias, targetName).Err(); err != nil {
+		activeSchema.Store(previousSchema)
+		return fmt.Errorf("pointing alias '%s' to %s: %w", indexAlias, targetName, err)
+	}
+	for _, client := range replicas.Clients() {
+		if err := waitForAlias(client, targetName, time.Second*30); err != nil {
+			return err
+		}
+	}
+	pinnedIndex.Store(nil)
+	fmt.Printf("[Migrate] Alias '%s' switched to %s everywhere in %v\n", indexAlias, targetName, time.Since(swapStart).Round(time.Millisecond))
+
+	// Without DD the driver hashes stay, only the old index structure is removed
+	if err := rdbMaster.Do(ctx, "FT.DROPINDEX", current).Err(); err != nil {
+		return fmt.Errorf("dropping %s: %w", current, err)
+	}
+	fmt.Printf("[Migrate] Dropped %s, migration took %v\n", current, time.Since(startTime).Round(time.Millisecond))
+
+	return nil
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+// SchemaField describes one attribute of the RediSearch index.
+type SchemaField struct {
+	Name      string
+	Type      string // NUMERIC, GEO, TEXT or TAG
+	Separator string // TAG only, RediSearch defaults to ","
+	Sortable  bool
+	NoIndex   bool
+}
+
+// driverSchema is the single definition of the driver index, every FT.CREATE and
+// schema verification is derived from it.
+var driverSchema = []SchemaField{
+	{Name: "driver_id", Type: "NUMERIC", Sortable: true},
+	{Name: "location", Type: "GEO"},
+	{Name: "geo_hash", Type: "TEXT"},
+	{Name: "active_tariffs", Type: "TAG", Separator: "|"},
+	{Name: "score", Type: "NUMERIC", Sortable: true},
+	{Name: "active", Type: "TAG"},
+	{Name: "phone_charge_percent", Type: "NUMERIC", NoIndex: true},
+	{Name: "last_updated_time", Type: "NUMERIC", NoIndex: true},
+}
+
+// withFields returns a copy of the schema with the given fields replaced by name.
+func withFields(schema []SchemaField, fields ...SchemaField) []SchemaField {
+	out := make([]SchemaField, len(schema))
+	copy(out, schema)
+	for _, f := range fields {
+		for i := range out {
+			if out[i].Name == f.Name {
+				out[i] = f
+			}
+		}
+	}
+	return out
+}
+
+// withoutFields returns a copy of the schema without the named fields.
+func withoutFields(schema []SchemaField, names ...string) []SchemaField {
+	out := []SchemaField{}
+	for _, f := range schema {
+		if !slices.Contains(names, f.Name) {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// activeSchema is the schema of the index the alias points to. It decides how
+// fields are encoded on write and how filters are written in queries.
+var activeSchema atomic.Pointer[[]SchemaField]
+
+func setActiveSchema(schema []SchemaField) {
+	activeSchema.Store(&schema)
+}
+
+// pinnedIndex, when set, names the versioned index queries go to instead of the
+// alias. MigrateIndex pins the new index while the alias moves, because replicas
+// switch the alias one by one and a query shaped for one schema fails on the other.
+var pinnedIndex atomic.Pointer[string]
+
+// searchIndex returns the index name queries should use.
+func searchIndex() string {
+	if name := pinnedIndex.Load(); name != nil {
+		return *name
+	}
+	return indexAlias
+}
+
+// activeFieldType returns the type of the field in the active schema.
+func activeFieldType(name string) string {
+	schema := driverSchema
+	if s := activeSchema.Load(); s != nil {
+		schema = *s
+	}
+	for _, f := range schema {
+		if f.Name == name {
+			return f.Type
+		}
+	}
+	return ""
+}
+
+// activeFieldIndexed reports whether the field can be used in query filters.
+func activeFieldIndexed(name string) bool {
+	schema := driverSchema
+	if s := activeSchema.Load(); s != nil {
+		schema = *s
+	}
+	for _, f := range schema {
+		if f.Name == name {
+			return !f.NoIndex
+		}
+	}
+	return false
+}
+
+// createIndexArgs builds the FT.CREATE command for the given schema over driver
+// hashes or, in JSON storage mode, driver documents.
+func createIndexArgs(index string, schema []SchemaField) []interface{} {
+	on := "HASH"
+	if storageMode == storageJSON {
+		on = "JSON"
+	}
+	args := []interface{}{"FT.CREATE", index, "ON", on, "PREFIX", "1", driverKeyPrefix(), "SCHEMA"}
+	for _, f := range schema {
+		if storageMode == storageJSON {
+			// Multi-value tags are arrays in the document, so no separator is needed
+			path := "$." + f.Name
+			if f.Separator != "" {
+				path += "[*]"
+			}
+			args = append(args, path, "AS", f.Name, f.Type)
+		} else {
+			args = append(args, f.Name, f.Type)
+			if f.Separator != "" {
+				args = append(args, "SEPARATOR", f.Separator)
+			}
+		}
+		if f.Sortable {
+			args = append(args, "SORTABLE")
+		}
+		if f.NoIndex {
+			args = append(args, "NOINDEX")
+		}
+	}
+	return args
+}
+
+// storageSchema is the schema FT.INFO is expected to report in the current
+// storage mode, JSON indexes have no TAG separators.
+func storageSchema(schema []SchemaField) []SchemaField {
+	if storageMode != storageJSON {
+		return schema
+	}
+	out := make([]SchemaField, len(schema))
+	copy(out, schema)
+	for i := range out {
+		out[i].Separator = ""
+	}
+	return out
+}
+
+// schemaFromInfo reads the index attributes reported by FT.INFO.
+func schemaFromInfo(info map[string]interface{}) ([]SchemaField, error) {
+	attributes, ok := info["attributes"].([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("FT.INFO reply has no attributes")
+	}
+
+	schema := make([]SchemaField, 0, len(attributes))
+	for _, attr := range attributes {
+		var field SchemaField
+		switch a := attr.(type) {
+		case []interface{}:
+			// RESP2: flat key/value pairs mixed with flags, e.g.
+			// identifier driver_id attribute driver_id type NUMERIC SORTABLE
+			for i := 0; i < len(a); i++ {
+				token := fmt.Sprint(a[i])
+				switch strings.ToLower(token) {
+				case "identifier", "weight", "phonetic":
+					i++
+				case "attribute":
+					if i+1 < len(a) {
+						field.Name = fmt.Sprint(a[i+1])
+					}
+					i++
+				case "type":
+					if i+1 < len(a) {
+						field.Type = fmt.Sprint(a[i+1])
+					}
+					i++
+				case "separator":
+					if i+1 < len(a) {
+						field.Separator = fmt.Sprint(a[i+1])
+					}
+					i++
+				default:
+					applySchemaFlag(&field, token)
+				}
+			}
+		case map[interface{}]interface{}:
+			// RESP3: a map with the flags in a nested list
+			field.Name = fmt.Sprint(a["attribute"])
+			field.Type = fmt.Sprint(a["type"])
+			if sep, ok := a["SEPARATOR"]; ok {
+				field.Separator = fmt.Sprint(sep)
+			}
+			if flags, ok := a["flags"].([]interface{}); ok {
+				for _, flag := range flags {
+					applySchemaFlag(&field, fmt.Sprint(flag))
+				}
+			}
+		default:
+			return nil, fmt.Errorf("unexpected FT.INFO attribute %T", attr)
+		}
+		schema = append(schema, field)
+	}
+	return schema, nil
+}
+
+func applySchemaFlag(field *SchemaField, flag string) {
+	switch strings.ToUpper(flag) {
+	case "SORTABLE":
+		field.Sortable = true
+	case "NOINDEX":
+		field.NoIndex = true
+	}
+}
+
+// compareSchema returns a description of every difference between the expected
+// and the actual schema, or nil when they match.
+func compareSchema(expected, actual []SchemaField) []string {
+	actualByName := map[string]SchemaField{}
+	for _, f := range actual {
+		actualByName[f.Name] = f
+	}
+
+	diffs := []string{}
+	for _, want := range expected {
+		got, ok := actualByName[want.Name]
+		if !ok {
+			diffs = append(diffs, fmt.Sprintf("field %s: missing", want.Name))
+			continue
+		}
+		delete(actualByName, want.Name)
+
+		if !strings.EqualFold(want.Type, got.Type) {
+			diffs = append(diffs, fmt.Sprintf("field %s: expected type %s, got %s", want.Name, want.Type, got.Type))
+		}
+		if want.Type == "TAG" && tagSeparator(want) != tagSeparator(got) {
+			diffs = append(diffs, fmt.Sprintf("field %s: expected separator %q, got %q", want.Name, tagSeparator(want), tagSeparator(got)))
+		}
+		if want.Sortable != got.Sortable {
+			diffs = append(diffs, fmt.Sprintf("field %s: expected SORTABLE=%t, got %t", want.Name, want.Sortable, got.Sortable))
+		}
+		if want.NoIndex != got.NoIndex {
+			diffs = append(diffs, fmt.Sprintf("field %s: expected NOINDEX=%t, got %t", want.Name, want.NoIndex, got.NoIndex))
+		}
+	}
+	for name := range actualByName {
+		diffs = append(diffs, fmt.Sprintf("field %s: not in schema definition", name))
+	}
+
+	if len(diffs) == 0 {
+		return nil
+	}
+	return diffs
+}
+
+func tagSeparator(f SchemaField) string {
+	if f.Separator == "" {
+		return ","
+	}
+	return f.Separator
+}
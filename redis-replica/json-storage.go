@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Storage layouts for drivers, selected with the storageMode constant.
+const (
+	storageHash = "hash" // flat hashes under driver:<id>
+	storageJSON = "json" // RedisJSON documents under driver_json:<id>
+)
+
+// JSON drivers use their own prefix so both datasets can live side by side
+// without WRONGTYPE errors when switching modes.
+func driverKeyPrefix() string {
+	if storageMode == storageJSON {
+		return "driver_json:"
+	}
+	return "driver:"
+}
+
+func driverKey(id int64) string {
+	return driverKeyPrefix() + strconv.FormatInt(id, 10)
+}
+
+// driverDocument is the RedisJSON representation of a driver. Unlike the hash
+// layout, tariffs are a real array and numbers and booleans keep their types.
+type driverDocument struct {
+	DriverID           int64       `json:"driver_id"`
+	Location           string      `json:"location"`
+	GeoHash            string      `json:"geo_hash"`
+	ActiveTariffs      []string    `json:"active_tariffs"`
+	Score              int64       `json:"score"`
+	Status             string      `json:"status,omitempty"`
+	Active             interface{} `json:"active,omitempty"` // legacy, bool or 1/0 when the schema indexes it as NUMERIC
+	PhoneChargePercent int64       `json:"phone_charge_percent"`
+	LastUpdatedTime    int64       `json:"last_updated_time"`
+}
+
+func encodeDriverDocument(in Driver) (string, error) {
+	lastUpdated, _ := strconv.ParseInt(in.LastUpdatedTime, 10, 64)
+
+	// The legacy active field follows the status while an index still uses it
+	var active interface{}
+	if in.Status != "" && activeFieldType("active") != "" {
+		active = in.Status == StatusFree
+		if activeFieldType("active") == "NUMERIC" {
+			active = encodeActive(in.Status == StatusFree)
+		}
+	}
+
+	doc, err := json.Marshal(driverDocument{
+		DriverID:           in.Id,
+		Location:           fmt.Sprintf("%f,%f", in.Location.Lat, in.Location.Long),
+		GeoHash:            in.GeoHash,
+		ActiveTariffs:      in.ActiveTariffs,
+		Score:              in.Score,
+		Status:             string(in.Status),
+		Active:             active,
+		PhoneChargePercent: in.Charge,
+		LastUpdatedTime:    lastUpdated,
+	})
+	return string(doc), err
+}
+
+// getDriverJSON reads a driver document, a missing key is ErrDriverNotFound
+// just like an empty HGETALL is for hashes.
+func getDriverJSON(client *redis.Client, key string) (Driver, error) {
+	raw, err := client.Do(ctx, "JSON.GET", key).Text()
+	if errors.Is(err, redis.Nil) {
+		return Driver{}, driverNotFound(key)
+	}
+	if err != nil {
+		return Driver{}, err
+	}
+	return decodeDriverDocument(key, raw)
+}
+
+// decodeDriverDocument converts a driver document into a Driver. Values of the
+// wrong type are reported as DriverParseError, a document that is not JSON as
+// one for wholeDocument.
+func decodeDriverDocument(key, raw string) (Driver, error) {
+	var doc driverDocument
+	errs := []error{}
+	if err := json.Unmarshal([]byte(raw), &doc); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if !errors.As(err, &typeErr) {
+			return Driver{}, &DriverParseError{Key: key, Field: wholeDocument, Value: raw, Err: err}
+		}
+		// The other fields are still decoded
+		errs = append(errs, &DriverParseError{Key: key, Field: typeErr.Field, Err: err})
+	}
+
+	driver := Driver{
+		Id:              doc.DriverID,
+		GeoHash:         doc.GeoHash,
+		ActiveTariffs:   doc.ActiveTariffs,
+		Score:           doc.Score,
+		Charge:          doc.PhoneChargePercent,
+		LastUpdatedTime: strconv.FormatInt(doc.LastUpdatedTime, 10),
+	}
+
+	if doc.Location != "" {
+		if l, err := parseLocation(doc.Location); err == nil {
+			driver.Location = l
+		} else {
+			errs = append(errs, &DriverParseError{Key: key, Field: "location", Value: doc.Location, Err: err})
+		}
+	}
+
+	if doc.Status != "" {
+		if s, err := parseStatus(doc.Status); err == nil {
+			driver.Status = s
+		} else {
+			errs = append(errs, &DriverParseError{Key: key, Field: "status", Value: doc.Status, Err: err})
+		}
+	} else {
+		// Written before statuses existed
+		driver.Status = StatusOffline
+		switch active := doc.Active.(type) {
+		case bool:
+			if active {
+				driver.Status = StatusFree
+			}
+		case float64:
+			if active == 1 {
+				driver.Status = StatusFree
+			}
+		}
+	}
+
+	return parsedDriver(driver, errs)
+}
+
+// printStorageReport prints the memory cost of the current storage mode so hash
+// and JSON runs can be compared.
+func printStorageReport() {
+	fmt.Println("\n|===== Storage =====|")
+	fmt.Printf("Storage mode: %s\n", storageMode)
+
+	var sampled, totalBytes int64
+	for i := 0; i < 200; i++ {
+		id := rand.Int63n(numDrivers) + 1
+		usage, err := rdbMaster.MemoryUsage(ctx, driverKey(id)).Result()
+		if err != nil {
+			continue
+		}
+		sampled++
+		totalBytes += usage
+	}
+	if sampled > 0 {
+		fmt.Printf("Average memory per driver: %d bytes (%d keys sampled)\n", totalBytes/sampled, sampled)
+	}
+
+	if info, err := ftInfo(rdbMaster, indexAlias); err == nil {
+		fmt.Printf("Index memory: %.1f MB for %d documents\n", indexMemoryMB(info), infoInt(info, "num_docs"))
+	}
+	if raw, err := rdbMaster.Info(ctx, "memory").Result(); err == nil {
+		for _, line := range strings.Split(raw, "\n") {
+			if value, ok := strings.CutPrefix(strings.TrimSpace(line), "used_memory_human:"); ok {
+				fmt.Printf("Master used memory: %s\n", value)
+			}
+		}
+	}
+}
@@ -2,8 +2,12 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"os"
+	"slices"
+	"sort"
 	"sync"
 	"time"
 
@@ -14,95 +18,388 @@ var (
 	rdbMaster        *redis.Client
 	replicas         *CustomLoadBalancer
 	masterAddr             = "localhost:6379"
-	replicaAddrs           = []string{"localhost:6380", "localhost:6381", "localhost:6382"}
+	replicaAddrs           = []string{} // discovered by Bootstrap
 	ctx                    = context.Background()
 	lastDriverId     int64 = 0
 	lastReadDriverId int64 = 0
 	mu                     = &sync.Mutex{}
 )
 
+// Storage layout of drivers, storageHash or storageJSON
+const storageMode = storageHash
+
+// How stored drivers are parsed, parseStrict or parseLenient
+const parseMode = parseLenient
+
+// How driver writes are sent to the master, see writeStrategies
+const writeStrategy = strategyPipeline
+
+// Write batch sizing of ConcurrentUpdates, batchFixed or batchAdaptive
+const writeBatchMode = batchFixed
+
 const (
 	testCycleCount              = 1
 	writeGoroutinesCount        = 1
+	writeBatchSize              = 100
+	seedGoroutinesCount         = 10
+	seedBatchSize               = 1_000
+	warmupDuration              = time.Second * 30
+	replicaReadyTimeout         = time.Minute * 5
+	bootstrapTimeout            = time.Minute * 2
+	minReplicaCount             = 3
+	replicaDiscoveryInterval    = time.Second * 5
+	migrateStartDelay           = time.Second * 15
+	migrateIndexingTimeout      = time.Minute * 10
+	experimentStepDuration      = time.Minute
 	readGoroutinesCount         = 35
 	numDrivers                  = 1_000_000
 	writeOpsPerMinute           = 1_000_000
 	singleGetOpsPerMinute       = 1_000_000
 	multiGetRadOpsPerMinute     = 1_500_000
 	multiGetGeoHashOpsPerMinute = 500_000
+	statusOpsPerMinute          = 100_000
+	statusGoroutinesCount       = 10
+	batchGetOpsPerMinute        = 600_000 // drivers looked up with GetDrivers per minute
+	batchGetSize                = 30      // drivers per GetDrivers call
+	batchGetGoroutinesCount     = 10
+	batchGetSplitReplicas       = false           // split GetDrivers calls across replicas
+	batchGetChunkSize           = 10              // ids per replica when splitting
+	dispatchMinCharge           = 20              // filtered workloads skip drivers below 20% battery
+	dispatchMaxAge              = time.Minute * 2 // and drivers without a ping in the last 2 minutes
+	presenceTTL                 = time.Minute * 5 // drivers without a ping for this long are set offline
+	presenceSweepInterval       = time.Second * 5
+	presenceSweepBatch          = 1_000
+	seedPingSpread              = time.Minute * 6  // seeded last pings lie within this window
+	staleUpdateRatio            = 0.02             // share of pings re-sent late with an older timestamp
+	duplicateUpdateRatio        = 0.01             // share of pings sent twice
+	maxUpdateDelay              = time.Second * 30 // how much older a late ping is
+	writeMixLocationShare       = 0.90             // share of write batches that are location pings
+	writeMixProfileShare        = 0.05             // tariff and score changes
+	writeMixFullShare           = 0.05             // whole driver documents
+)
+
+// Adaptive batching settings, used when writeBatchMode is batchAdaptive
+const (
+	adaptiveMinBatch       = 10
+	adaptiveMaxBatch       = 2_000
+	adaptiveBatchStep      = 50 // additive increase per interval
+	adaptiveMaxPipelines   = 16
+	adaptiveTargetLatency  = time.Millisecond * 20 // mean round trip above this halves batch and pipelines
+	adaptiveThroughputDrop = 0.1                   // so does a 10% throughput drop after growing
+	adaptiveAdjustInterval = time.Second
+	adaptiveReportInterval = time.Second * 5
+)
+
+// Driver reservation benchmark settings, used by `go run . reserve`
+const (
+	reservationGoroutinesCount  = 50
+	reservationDuration         = time.Minute
+	reservationHotspots         = 20
+	reservationGeoHashPrecision = 4 // cells of roughly 39km x 20km
+	reservationCandidates       = 10
+	reservationTripDuration     = time.Millisecond * 20
+	reservationHoldTTL          = time.Second * 30
+)
+
+// Stream ingestion benchmark settings, used by `go run . ingest`
+const (
+	ingestProducersCount = 20
+	ingestPingsPerMinute = 600_000
+	ingestBatchSize      = 100 // pings per producer batch and per XREADGROUP
+	ingestDuration       = time.Minute
+	ingestConsumersCount = 4
+	ingestClaimIdle      = time.Second * 5 // pending entries idle this long are reclaimed
+	ingestDropRatio      = 0.001           // share of batches a consumer abandons without XACK
+	ingestDrainTimeout   = time.Second * 30
+	ingestStreamMaxLen   = 1_000_000
+	ingestProbeRatio     = 0.001 // share of pings followed until they are searchable
+	ingestProbeTimeout   = time.Second * 10
 )
 
+// Write coalescing benchmark settings, used by `go run . coalesce`
+const (
+	coalesceProducersCount  = 20
+	coalesceWritesPerMinute = 1_000_000
+	coalesceActiveDrivers   = 20_000 // each active driver is updated about every 1.2s
+	coalesceDuration        = time.Second * 30
+	coalesceFlushBatch      = 100 // drivers per flush pipeline
+	coalesceFlushPipelines  = 8   // pipelines in flight during a flush
+)
+
+var coalesceWindows = []time.Duration{time.Millisecond * 100, time.Millisecond * 500, time.Second * 2}
+
+// Write strategy matrix settings, used by `go run . strategies`
+const (
+	strategyGoroutinesCount = 10
+	strategyStepDuration    = time.Second * 30
+)
+
+// Sentinel settings. With useSentinel the master and replica addresses are
+// discovered from the sentinels instead of masterAddr and replicaAddrs, and the
+// master client follows failovers.
+const (
+	useSentinel           = false
+	sentinelMasterName    = "mymaster"
+	failoverStartDelay    = time.Second * 20
+	failoverTimeout       = time.Minute
+	failoverWriteInterval = time.Millisecond * 5
+)
+
+var sentinelAddrs = []string{"localhost:26379", "localhost:26380", "localhost:26381"}
+
+// Sentinels and the master announce the addresses of the docker network, these
+// are mapped to the ports published on the host.
+var announcedAddrs = map[string]string{
+	"172.40.0.10:6379": "localhost:6379",
+	"172.40.0.11:6379": "localhost:6380",
+	"172.40.0.12:6379": "localhost:6381",
+	"172.40.0.13:6379": "localhost:6382",
+}
+
+// Saturation search settings, used by `go run . saturate <workload>`
+const (
+	saturationGoroutinesCount  = 35
+	saturationStepDuration     = time.Second * 20
+	saturationStartRate        = 50_000
+	saturationMaxRate          = 10_000_000
+	saturationMinRate          = 1_000 // lowest rate tried when the start rate already breaks the SLO
+	saturationPrecision        = 0.05  // stop when the search window is within 5% of the knee
+	saturationP99Target        = time.Millisecond * 20
+	saturationErrorBudget      = 0.001
+	saturationMinAchievedRatio = 0.95
+)
+
+// SLO thresholds evaluated after the benchmark run, the process exits with a
+// non-zero code when any of them is violated. Zero values are not checked.
+var sloThresholds = map[string]SLOThreshold{
+	"write": {
+		MinOpsPerMinute:   writeOpsPerMinute / 2,
+		MaxP99:            time.Millisecond * 50,
+		MaxErrorRatio:     0.001,
+		MaxReplicationLag: time.Second,
+	},
+	"single-get": {
+		MinOpsPerMinute: singleGetOpsPerMinute / 2,
+		MaxP99:          time.Millisecond * 10,
+		MaxErrorRatio:   0.001,
+	},
+	"radius": {
+		MinOpsPerMinute: multiGetRadOpsPerMinute / 2,
+		MaxP99:          time.Millisecond * 20,
+		MaxErrorRatio:   0.001,
+	},
+	"geohash": {
+		MinOpsPerMinute: multiGetGeoHashOpsPerMinute / 2,
+		MaxP99:          time.Millisecond * 20,
+		MaxErrorRatio:   0.001,
+	},
+	"batch-get": {
+		MinOpsPerMinute: batchGetOpsPerMinute / 2,
+		MaxP99:          time.Millisecond * 10,
+		MaxErrorRatio:   0.001,
+	},
+	"status": {
+		MinOpsPerMinute: statusOpsPerMinute / 2,
+		MaxP99:          time.Millisecond * 10,
+		MaxErrorRatio:   0.001,
+	},
+}
+
 func main() {
-	// Connect to Redis
-	rdbMaster = redis.NewClient(&redis.Options{
-		Addr: masterAddr,
-	})
-	_, err := rdbMaster.Ping(ctx).Result()
-	if err != nil {
-		log.Fatal("Redis master connection error:", err)
-	}
-	replicas, err = NewLoadBalancer(replicaAddrs)
-	if err != nil {
-		log.Fatal("Redis replicas connection error:", err)
-	}
-
-	var (
-		totalWriteOperations int
-		totalWriteErrors     int
-		totalReadOperations  int
-		totalReadErrors      int
-	)
+	command := ""
+	if len(os.Args) > 1 {
+		command = os.Args[1]
+	}
+
+	// Connect to Redis and make sure the index matches driverSchema everywhere
+	if err := Bootstrap(bootstrapTimeout); err != nil {
+		log.Fatalf("Bootstrap failed: %v", err)
+	}
+	if command == "bootstrap" {
+		return
+	}
+
+	if _, err := SeedDrivers(); err != nil {
+		log.Fatalf("Failed to seed drivers: %v", err)
+	}
+	if _, err := WaitForReplicas(replicaReadyTimeout); err != nil {
+		log.Fatalf("Replicas are not ready: %v", err)
+	}
+
+	if command != "" {
+		switch command {
+		case "migrate":
+			// Keep the benchmark running so the report shows the impact of the reindex
+			runBenchmark(func() {
+				time.Sleep(migrateStartDelay)
+				if err := MigrateIndex(); err != nil {
+					log.Printf("[Migrate] Migration failed: %v", err)
+				}
+			})
+		case "experiment":
+			workloadName := "mix"
+			if len(os.Args) > 2 {
+				workloadName = os.Args[2]
+			}
+			if err := runSchemaExperiments(workloadName); err != nil {
+				log.Fatalf("Schema experiments failed: %v", err)
+			}
+		case "failover":
+			if !useSentinel {
+				log.Fatalf("The failover command needs useSentinel = true")
+			}
+			runBenchmark(func() {
+				report, err := MeasureFailover()
+				if err != nil {
+					log.Printf("[Failover] Measurement failed: %v", err)
+				}
+				printFailoverReport(report)
+			})
+		case "reserve":
+			if err := runReservationBenchmark(); err != nil {
+				log.Fatalf("Reservation benchmark failed: %v", err)
+			}
+		case "ingest":
+			if err := runIngestBenchmark(); err != nil {
+				log.Fatalf("Ingestion benchmark failed: %v", err)
+			}
+		case "coalesce":
+			runCoalescingBenchmark()
+		case "strategies":
+			runWriteStrategyMatrix()
+		case "saturate":
+			workloadName := "mix"
+			if len(os.Args) > 2 {
+				workloadName = os.Args[2]
+			}
+			if err := runSaturationSearch(workloadName); err != nil {
+				log.Fatalf("Saturation search failed: %v", err)
+			}
+		default:
+			log.Fatalf("Unknown command %q, expected: bootstrap | migrate | failover | reserve | ingest | coalesce | strategies | experiment [workload] | saturate [workload]", command)
+		}
+		return
+	}
+
+	runBenchmark(nil)
+}
+
+// runBenchmark measures all four workloads. duringMeasurement, if set, is started
+// together with the workloads, e.g. to measure the impact of a migration.
+func runBenchmark(duringMeasurement func()) {
+	Warmup()
+
+	fmt.Println("Starting measurement...")
+	results := map[string]LoadResult{}
 	wg := &sync.WaitGroup{}
 	mt := &sync.Mutex{}
-	wg.Add(4)
+	record := func(name string, result LoadResult) {
+		mt.Lock()
+		defer mt.Unlock()
+		results[name] = result
+	}
+
+	resetUpdateOrdering()
+	resetWriteMix()
+	measurementStart := time.Now()
+	lagProbe := StartReplicationLagProbe(time.Second)
+	discovery := StartReplicaDiscovery(replicaDiscoveryInterval)
+	sweeper := StartPresenceSweeper(presenceSweepInterval)
+	if duringMeasurement != nil {
+		wg.Add(1)
+		go func(w *sync.WaitGroup) {
+			defer w.Done()
+			duringMeasurement()
+		}(wg)
+	}
+
+	wg.Add(6)
 	go func(w *sync.WaitGroup) {
 		defer w.Done()
-		totalWriteOperations, totalWriteErrors = ConcurrentUpdates()
+		record("write", ConcurrentUpdates())
 	}(wg)
-	time.Sleep(time.Second * 20)
 	go func(w *sync.WaitGroup) {
 		defer w.Done()
-		ops, errCount := ConcurrentSingleGets()
-		fmt.Println("ConcurrentSingleGets Operation count - ", ops)
-		mt.Lock()
-		defer mt.Unlock()
-		totalReadOperations += ops
-		totalReadErrors += errCount
+		result := ConcurrentSingleGets()
+		fmt.Println("ConcurrentSingleGets Operation count - ", result.Operations)
+		record("single-get", result)
+	}(wg)
+	go func(w *sync.WaitGroup) {
+		defer w.Done()
+		result := ConcurrentListGetInRaius()
+		fmt.Println("ConcurrentListGetInRaius Operation count - ", result.Operations)
+		record("radius", result)
 	}(wg)
 	go func(w *sync.WaitGroup) {
 		defer w.Done()
-		ops, errCount := ConcurrentListGetInRaius()
-		fmt.Println("ConcurrentListGetInRaius Operation count - ", ops)
-		mu.Lock()
-		defer mu.Unlock()
-		totalReadOperations += ops
-		totalReadErrors += errCount
+		result := ConcurrentListInGeoHash()
+		fmt.Println("ConcurrentListInGeoHash Operation count - ", result.Operations)
+		record("geohash", result)
 	}(wg)
 	go func(w *sync.WaitGroup) {
 		defer w.Done()
-		ops, errCount := ConcurrentListInGeoHash()
-		fmt.Println("ConcurrentListInGeoHash Operation count - ", ops)
-		mu.Lock()
-		defer mu.Unlock()
-		totalReadOperations += ops
-		totalReadErrors += errCount
+		result := ConcurrentBatchGets()
+		fmt.Println("ConcurrentBatchGets Operation count - ", result.Operations)
+		record("batch-get", result)
+	}(wg)
+	go func(w *sync.WaitGroup) {
+		defer w.Done()
+		result := ConcurrentStatusTransitions()
+		fmt.Println("ConcurrentStatusTransitions Operation count - ", result.Operations)
+		record("status", result)
 	}(wg)
 
 	wg.Wait()
+	replicationLag := lagProbe.Stop()
+	discovery.Stop()
+	presence := sweeper.Stop()
+
+	var totalReadOperations, totalReadErrors, totalReadNotFound, totalReadMalformed int
+	for _, name := range []string{"single-get", "batch-get", "radius", "geohash"} {
+		totalReadOperations += results[name].Operations
+		totalReadErrors += results[name].Errors
+		totalReadNotFound += results[name].NotFound
+		totalReadMalformed += results[name].Malformed
+	}
 
 	fmt.Println("\n|===== Summary of Write operations =====|")
-	fmt.Printf("Total Write Operations: %d\n", totalWriteOperations)
-	fmt.Printf("Total Write Operations per minute: %d\n", totalWriteOperations/testCycleCount)
-	fmt.Printf("Total Write Errors: %d\n", totalWriteErrors)
+	fmt.Printf("Total Write Operations: %d\n", results["write"].Operations)
+	fmt.Printf("Total Write Operations per minute: %d\n", results["write"].AchievedOpsPerMinute)
+	fmt.Printf("Total Write Errors: %d\n", results["write"].Errors)
+	printUpdateOrdering()
+	printWriteMix()
+	printWriteBatching()
 	fmt.Println("\n|===== Summary of Read operations =====|")
 	fmt.Printf("Total Read Operations: %d\n", totalReadOperations)
 	fmt.Printf("Total Read Operations per minute: %d\n", totalReadOperations/testCycleCount)
 	fmt.Printf("Total Read Errors: %d\n", totalReadErrors)
+	fmt.Printf("Total Reads of missing drivers: %d\n", totalReadNotFound)
+	fmt.Printf("Total Reads of malformed drivers (%s parsing): %d\n", parseMode, totalReadMalformed)
+	fmt.Println("\n|===== Summary of Status transitions =====|")
+	fmt.Printf("Total Transitions: %d, errors %d\n", results["status"].Operations, results["status"].Errors)
+	fmt.Printf("Transitions lost to a concurrent writer: %d\n", results["status"].LostRaces)
+	fmt.Println("\n|===== Latency per workload =====|")
+	for _, w := range benchmarkWorkloads {
+		l := results[w.Name].Latency
+		fmt.Printf("%-12s p50 %-10v p95 %-10v p99 %-10v max %v\n", w.Name,
+			l.P50.Round(time.Microsecond), l.P95.Round(time.Microsecond), l.P99.Round(time.Microsecond), l.Max.Round(time.Microsecond))
+	}
+	fmt.Printf("Replication lag: p99 %v, max %v\n", replicationLag.P99.Round(time.Microsecond), replicationLag.Max.Round(time.Microsecond))
+	printTopologyChanges(replicas.ChangesSince(measurementStart), measurementStart)
+	printPresenceReport(presence)
+	printStorageReport()
+
+	if !evaluateSLOs(sloThresholds, results, replicationLag) {
+		fmt.Println("\nSLO violated")
+		os.Exit(1)
+	}
 }
 
 type CustomLoadBalancer struct {
-	clients []*redis.Client
-	offset  int
+	clients  []*redis.Client
+	offset   int
+	updateMu sync.Mutex
+	changes  []TopologyChange
 }
 
 func NewLoadBalancer(addrs []string) (*CustomLoadBalancer, error) {
@@ -136,3 +433,84 @@ func (cl *CustomLoadBalancer) Get() *redis.Client {
 	cl.offset++
 	return client
 }
+
+// Clients returns a snapshot of the replica clients currently in the pool.
+func (cl *CustomLoadBalancer) Clients() []*redis.Client {
+	mu.Lock()
+	defer mu.Unlock()
+
+	clients := make([]*redis.Client, len(cl.clients))
+	copy(clients, cl.clients)
+	return clients
+}
+
+// Update replaces the pool with the given addresses. Clients of addresses that
+// stay are kept, new addresses are connected and departed ones are closed after
+// a grace period so in-flight reads can finish. Nothing changes if a new address
+// cannot be reached, and the pool is never emptied.
+func (cl *CustomLoadBalancer) Update(addrs []string) (added, removed []string, err error) {
+	if len(addrs) == 0 {
+		return nil, nil, errors.New("refusing to empty the replica pool")
+	}
+
+	cl.updateMu.Lock()
+	defer cl.updateMu.Unlock()
+
+	current := map[string]*redis.Client{}
+	for _, client := range cl.Clients() {
+		current[client.Options().Addr] = client
+	}
+
+	clients := []*redis.Client{}
+	for _, addr := range addrs {
+		if client, ok := current[addr]; ok {
+			clients = append(clients, client)
+			delete(current, addr)
+			continue
+		}
+
+		client := redis.NewClient(&redis.Options{
+			Addr: addr,
+		})
+		if _, err := client.Ping(ctx).Result(); err != nil {
+			client.Close()
+			for _, c := range clients {
+				if slices.Contains(added, c.Options().Addr) {
+					c.Close()
+				}
+			}
+			return nil, nil, fmt.Errorf("connecting to replica %s: %w", addr, err)
+		}
+		clients = append(clients, client)
+		added = append(added, addr)
+	}
+
+	mu.Lock()
+	cl.clients = clients
+	mu.Unlock()
+
+	for addr, client := range current {
+		removed = append(removed, addr)
+		time.AfterFunc(time.Second*5, func() { client.Close() })
+	}
+	sort.Strings(removed)
+
+	if len(added) > 0 || len(removed) > 0 {
+		cl.changes = append(cl.changes, TopologyChange{At: time.Now(), Added: added, Removed: removed})
+	}
+	return added, removed, nil
+}
+
+// ChangesSince returns the pool changes made after the given time.
+func (cl *CustomLoadBalancer) ChangesSince(since time.Time) []TopologyChange {
+	cl.updateMu.Lock()
+	defer cl.updateMu.Unlock()
+
+	changes := []TopologyChange{}
+	for _, change := range cl.changes {
+		if change.At.After(since) {
+			changes = append(changes, change)
+		}
+	}
+	return changes
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Every write records the driver's last ping in this sorted set, scored by
+// last_updated_time, so stale drivers can be found without scanning the index.
+const heartbeatKey = "driver_heartbeats"
+
+// expireDriversScript sets drivers offline whose heartbeat is still at or before
+// the cutoff and drops them from the heartbeat set until their next ping. Only
+// statuses driverTransitions lets go offline are expired. Reserved and en_route
+// drivers keep their status and heartbeat, they are expired once they move on.
+// KEYS[1] is the heartbeat set followed by the driver keys, ARGV the cutoff, the
+// storage mode, the legacy active value for offline and the driver ids. It
+// returns the last ping of every driver it set offline and the number of stale
+// drivers it kept.
+var expireDriversScript = redis.NewScript(luaStatusFunctions + luaTransitionTable() + `
+local expired = {}
+local kept = 0
+for i = 2, #KEYS do
+	local id = ARGV[i + 2]
+	local lastPing = redis.call('ZSCORE', KEYS[1], id)
+	if lastPing and tonumber(lastPing) <= tonumber(ARGV[1]) then
+		local status = get_status(KEYS[i], ARGV[2])
+		if status and status ~= 'offline' and not (allowed[status] and allowed[status]['offline']) then
+			kept = kept + 1
+		else
+			if status and status ~= 'offline' then
+				set_status(KEYS[i], ARGV[2], 'offline', ARGV[3])
+				table.insert(expired, tonumber(lastPing))
+			end
+			redis.call('ZREM', KEYS[1], id)
+		end
+	end
+end
+return {expired, kept}
+`)
+
+// PresenceReport summarizes what the sweeper did during a run.
+type PresenceReport struct {
+	Sweeps  int
+	Expired int
+	Errors  int
+	// Stale drivers left in a status that cannot go offline, once per sweep
+	Kept int
+	// Reserved drivers freed because their hold expired
+	HoldsExpired int
+	// Delay between a driver becoming stale (last ping + presenceTTL) and the
+	// sweeper setting it offline
+	Delay LatencySummary
+}
+
+// PresenceSweeper periodically sets drivers offline that have not pinged for presenceTTL.
+type PresenceSweeper struct {
+	stop   chan struct{}
+	done   chan struct{}
+	mu     sync.Mutex
+	report PresenceReport
+	delays []time.Duration
+}
+
+func StartPresenceSweeper(interval time.Duration) *PresenceSweeper {
+	s := &PresenceSweeper{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+				s.sweep()
+			}
+		}
+	}()
+
+	return s
+}
+
+// sweep expires stale drivers in batches until none are left, then frees
+// reserved drivers whose hold expired.
+func (s *PresenceSweeper) sweep() {
+	s.mu.Lock()
+	s.report.Sweeps++
+	s.mu.Unlock()
+
+	s.expireDrivers()
+
+	freed, err := expireHolds()
+	s.mu.Lock()
+	s.report.HoldsExpired += freed
+	s.mu.Unlock()
+	if err != nil {
+		s.fail(err)
+	}
+}
+
+func (s *PresenceSweeper) expireDrivers() {
+	// Stale drivers the script keeps stay at the front of the set, skip past them
+	offset := int64(0)
+	for {
+		cutoff := time.Now().Add(-presenceTTL).UnixMilli()
+		ids, err := rdbMaster.ZRangeByScore(ctx, heartbeatKey, &redis.ZRangeBy{
+			Min:    "-inf",
+			Max:    strconv.FormatInt(cutoff, 10),
+			Offset: offset,
+			Count:  presenceSweepBatch,
+		}).Result()
+		if err != nil {
+			s.fail(fmt.Errorf("reading stale drivers: %w", err))
+			return
+		}
+		if len(ids) == 0 {
+			return
+		}
+
+		keys := []string{heartbeatKey}
+		args := []interface{}{cutoff, storageMode, legacyActive(StatusOffline)}
+		for _, member := range ids {
+			id, err := strconv.ParseInt(member, 10, 64)
+			if err != nil {
+				continue
+			}
+			keys = append(keys, driverKey(id))
+			args = append(args, member)
+		}
+
+		reply, err := expireDriversScript.Run(ctx, rdbMaster, keys, args...).Slice()
+		if err != nil {
+			s.fail(fmt.Errorf("expiring drivers: %w", err))
+			return
+		}
+		lastPings, kept, err := parseExpireReply(reply)
+		if err != nil {
+			s.fail(fmt.Errorf("expiring drivers: %w", err))
+			return
+		}
+		offset += kept
+
+		now := time.Now()
+		s.mu.Lock()
+		s.report.Expired += len(lastPings)
+		s.report.Kept += int(kept)
+		for _, lastPing := range lastPings {
+			s.delays = append(s.delays, now.Sub(time.UnixMilli(lastPing).Add(presenceTTL)))
+		}
+		s.mu.Unlock()
+
+		if len(ids) < presenceSweepBatch {
+			return
+		}
+	}
+}
+
+// parseExpireReply splits the reply of expireDriversScript into the last pings
+// of the expired drivers and the number of kept ones.
+func parseExpireReply(reply []interface{}) ([]int64, int64, error) {
+	if len(reply) != 2 {
+		return nil, 0, fmt.Errorf("unexpected reply %v", reply)
+	}
+	pings, ok := reply[0].([]interface{})
+	kept, okKept := reply[1].(int64)
+	if !ok || !okKept {
+		return nil, 0, fmt.Errorf("unexpected reply %v", reply)
+	}
+	lastPings := make([]int64, 0, len(pings))
+	for _, p := range pings {
+		n, ok := p.(int64)
+		if !ok {
+			return nil, 0, fmt.Errorf("unexpected last ping %v", p)
+		}
+		lastPings = append(lastPings, n)
+	}
+	return lastPings, kept, nil
+}
+
+func (s *PresenceSweeper) fail(err error) {
+	log.Printf("[Presence] %v", err)
+	s.mu.Lock()
+	s.report.Errors++
+	s.mu.Unlock()
+}
+
+// Stop ends the sweeper and returns what it did.
+func (s *PresenceSweeper) Stop() PresenceReport {
+	close(s.stop)
+	<-s.done
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	report := s.report
+	report.Delay = summarizeLatencies(s.delays)
+	return report
+}
+
+func printPresenceReport(report PresenceReport) {
+	fmt.Println("\n|===== Presence =====|")
+	fmt.Printf("Drivers set offline after %v without a ping: %d in %d sweeps (%d errors)\n",
+		presenceTTL, report.Expired, report.Sweeps, report.Errors)
+	if report.Expired > 0 {
+		fmt.Printf("Expiry delay: p50 %v, p99 %v, max %v\n",
+			report.Delay.P50.Round(time.Millisecond), report.Delay.P99.Round(time.Millisecond), report.Delay.Max.Round(time.Millisecond))
+	}
+	fmt.Printf("Reserved drivers freed after their hold expired: %d\n", report.HoldsExpired)
+	fmt.Printf("Stale drivers kept because their status cannot go offline: %d (summed over sweeps)\n", report.Kept)
+	if stale, err := rdbMaster.ZCount(ctx, heartbeatKey, "-inf", strconv.FormatInt(time.Now().Add(-presenceTTL).UnixMilli(), 10)).Result(); err == nil {
+		fmt.Printf("Stale drivers not yet swept: %d\n", stale)
+	}
+}
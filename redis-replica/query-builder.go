@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Query is a RediSearch query expression. Clauses are created with the
+// constructors below, which escape every value, and combined with And, Or and
+// Not, so callers never paste raw input into a query string.
+type Query struct {
+	expr     string
+	compound bool // needs parentheses when nested
+}
+
+func (q Query) String() string {
+	return q.expr
+}
+
+// MatchAll matches every document in the index.
+func MatchAll() Query {
+	return Query{expr: "*"}
+}
+
+// Geo matches a GEO field within radiusKm of the location.
+func Geo(field string, location Location, radiusKm float64) Query {
+	return Query{expr: fmt.Sprintf("@%s:[%f %f %f km]", field, location.Long, location.Lat, radiusKm)}
+}
+
+// Tag matches a TAG field holding any of the values. At least one value is
+// required, calling it without one is a programming error and panics.
+func Tag(field string, values ...string) Query {
+	if len(values) == 0 {
+		panic(fmt.Sprintf("query builder: Tag(%q) needs at least one value", field))
+	}
+	escaped := make([]string, len(values))
+	for i, v := range values {
+		escaped[i] = escapeQueryValue(v)
+	}
+	return Query{expr: fmt.Sprintf("@%s:{%s}", field, strings.Join(escaped, "|"))}
+}
+
+// TagPrefix matches a TAG field with a value starting with prefix.
+func TagPrefix(field, prefix string) Query {
+	return Query{expr: fmt.Sprintf("@%s:{%s*}", field, escapeQueryValue(prefix))}
+}
+
+// TextPrefix matches a TEXT field with a term starting with prefix.
+func TextPrefix(field, prefix string) Query {
+	return Query{expr: fmt.Sprintf("@%s:%s*", field, escapeQueryValue(prefix))}
+}
+
+// NumericRange matches a NUMERIC field between min and max, both inclusive.
+// Infinite bounds leave that side open.
+func NumericRange(field string, min, max float64) Query {
+	return Query{expr: fmt.Sprintf("@%s:[%s %s]", field, formatBound(min), formatBound(max))}
+}
+
+// And matches documents matching every clause, at least one is required.
+func And(clauses ...Query) Query {
+	return combine(clauses, " ")
+}
+
+// Or matches documents matching any of the clauses, at least one is required.
+func Or(clauses ...Query) Query {
+	return combine(clauses, "|")
+}
+
+// Not matches documents that do not match the clause.
+func Not(clause Query) Query {
+	return Query{expr: "-" + clause.nested()}
+}
+
+func combine(clauses []Query, operator string) Query {
+	if len(clauses) == 0 {
+		panic("query builder: And/Or need at least one clause")
+	}
+	if len(clauses) == 1 {
+		return clauses[0]
+	}
+	parts := make([]string, len(clauses))
+	for i, c := range clauses {
+		parts[i] = c.nested()
+	}
+	return Query{expr: strings.Join(parts, operator), compound: true}
+}
+
+// RediSearch gives | a higher precedence than intersection, nested compound
+// clauses are therefore always parenthesized.
+func (q Query) nested() string {
+	if q.compound {
+		return "(" + q.expr + ")"
+	}
+	return q.expr
+}
+
+// escapeQueryValue backslash-escapes everything except letters, digits and
+// underscores, which covers the tag separators, wildcards, operators and
+// spaces of the query syntax, e.g. comfort+ becomes comfort\+.
+func escapeQueryValue(value string) string {
+	var b strings.Builder
+	for _, r := range value {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func formatBound(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+inf"
+	case math.IsInf(v, -1):
+		return "-inf"
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
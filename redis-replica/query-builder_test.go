@@ -0,0 +1,79 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestQueryBuilder(t *testing.T) {
+	a := Tag("a", "x")
+	b := Tag("b", "y")
+	c := Tag("c", "z")
+
+	tests := []struct {
+		name  string
+		query Query
+		want  string
+	}{
+		{"match all", MatchAll(), `*`},
+		{"tag", Tag("active_tariffs", "start"), `@active_tariffs:{start}`},
+		{"tag plus", Tag("active_tariffs", "comfort+"), `@active_tariffs:{comfort\+}`},
+		{"tag values", Tag("active_tariffs", "start", "comfort+"), `@active_tariffs:{start|comfort\+}`},
+		{"tag space", Tag("city", "new york"), `@city:{new\ york}`},
+		{"tag pipe", Tag("f", "a|b"), `@f:{a\|b}`},
+		{"tag braces", Tag("f", "{x}"), `@f:{\{x\}}`},
+		{"tag star", Tag("f", "a*"), `@f:{a\*}`},
+		{"tag dash", Tag("f", "-x"), `@f:{\-x}`},
+		{"tag underscore", Tag("status", "en_route"), `@status:{en_route}`},
+		{"tag non-ASCII", Tag("city", "Тошкент", "Straße"), `@city:{Тошкент|Straße}`},
+		{"tag prefix", TagPrefix("geo_hash", "txk"), `@geo_hash:{txk*}`},
+		{"tag prefix escaped", TagPrefix("geo_hash", "tx k*"), `@geo_hash:{tx\ k\**}`},
+		{"text prefix", TextPrefix("geo_hash", "txk"), `@geo_hash:txk*`},
+		{"text prefix escaped", TextPrefix("geo_hash", "a-b|c"), `@geo_hash:a\-b\|c*`},
+		{"numeric range", NumericRange("score", 1.5, 2), `@score:[1.5 2]`},
+		{"numeric open max", NumericRange("phone_charge_percent", 20, math.Inf(1)), `@phone_charge_percent:[20 +inf]`},
+		{"numeric open min", NumericRange("score", math.Inf(-1), 50), `@score:[-inf 50]`},
+		{"numeric unbounded", NumericRange("score", math.Inf(-1), math.Inf(1)), `@score:[-inf +inf]`},
+		{"and single", And(a), `@a:{x}`},
+		{"or single", Or(a), `@a:{x}`},
+		{"and", And(a, b, c), `@a:{x} @b:{y} @c:{z}`},
+		{"or", Or(a, b), `@a:{x}|@b:{y}`},
+		{"and of or", And(a, Or(b, c)), `@a:{x} (@b:{y}|@c:{z})`},
+		{"or of and", Or(And(a, b), c), `(@a:{x} @b:{y})|@c:{z}`},
+		{"not", Not(a), `-@a:{x}`},
+		{"not or", Not(Or(a, b)), `-(@a:{x}|@b:{y})`},
+		{"nested not", Not(And(Not(a), b)), `-(-@a:{x} @b:{y})`},
+		{"and with not", And(a, Not(Or(b, c))), `@a:{x} -(@b:{y}|@c:{z})`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.query.String(); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryBuilderRejectsEmpty(t *testing.T) {
+	tests := []struct {
+		name  string
+		build func() Query
+	}{
+		{"tag without values", func() Query { return Tag("active_tariffs") }},
+		{"and without clauses", func() Query { return And() }},
+		{"or without clauses", func() Query { return Or() }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("expected a panic")
+				}
+			}()
+			q := tt.build()
+			t.Errorf("built %q", q)
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// TopologyChange records replicas joining or leaving the read pool.
+type TopologyChange struct {
+	At      time.Time
+	Added   []string
+	Removed []string
+}
+
+// masterReplicas returns the online replicas the master reports in the slaveN
+// lines of INFO replication, e.g.
+//
+//	slave0:ip=172.40.0.11,port=6379,state=online,offset=1234,lag=0
+func masterReplicas() ([]string, error) {
+	info, err := replicationInfo(rdbMaster)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := []string{}
+	for key, value := range info {
+		n, ok := strings.CutPrefix(key, "slave")
+		if !ok {
+			continue
+		}
+		if _, err := strconv.Atoi(n); err != nil {
+			continue // slave_read_only, slave_repl_offset, ...
+		}
+
+		fields := map[string]string{}
+		for _, pair := range strings.Split(value, ",") {
+			if k, v, ok := strings.Cut(pair, "="); ok {
+				fields[k] = v
+			}
+		}
+		if fields["state"] != "online" {
+			continue
+		}
+		addrs = append(addrs, hostAddr(net.JoinHostPort(fields["ip"], fields["port"])))
+	}
+	sort.Strings(addrs)
+	return addrs, nil
+}
+
+// waitForMasterReplicas polls the master until it reports at least
+// minReplicaCount online replicas or the deadline passes.
+func waitForMasterReplicas(deadline time.Time) ([]string, error) {
+	fmt.Printf("[Bootstrap] Waiting for %d replicas to attach to %s", minReplicaCount, masterAddr)
+	for {
+		addrs, err := masterReplicas()
+		if err == nil && len(addrs) >= minReplicaCount {
+			fmt.Printf(" found %s\n", strings.Join(addrs, ", "))
+			return addrs, nil
+		}
+		if time.Now().After(deadline) {
+			fmt.Println()
+			if err == nil {
+				err = fmt.Errorf("master reports %d online replicas %v", len(addrs), addrs)
+			}
+			return nil, err
+		}
+		fmt.Print(".")
+		time.Sleep(time.Second)
+	}
+}
+
+// ReplicaDiscovery periodically re-reads the master's replica list and updates
+// the read pool, so replicas can be added or removed during a run.
+type ReplicaDiscovery struct {
+	stop chan struct{}
+	done chan struct{}
+}
+
+func StartReplicaDiscovery(interval time.Duration) *ReplicaDiscovery {
+	d := &ReplicaDiscovery{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+
+	go func() {
+		defer close(d.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-d.stop:
+				return
+			case <-ticker.C:
+				refreshReplicas()
+			}
+		}
+	}()
+
+	return d
+}
+
+func (d *ReplicaDiscovery) Stop() {
+	close(d.stop)
+	<-d.done
+}
+
+func refreshReplicas() {
+	addrs, err := masterReplicas()
+	if err != nil {
+		log.Printf("[Discovery] Error reading replicas from master: %v", err)
+		return
+	}
+	if len(addrs) == 0 {
+		log.Printf("[Discovery] Master reports no online replicas, keeping the current pool")
+		return
+	}
+
+	addrs, pending, err := readyAddrs(addrs)
+	if err != nil {
+		log.Printf("[Discovery] Error checking replica readiness: %v", err)
+		return
+	}
+	if len(pending) > 0 {
+		log.Printf("[Discovery] Replicas not ready yet, retrying next tick: %v", pending)
+	}
+	if len(addrs) == 0 {
+		return
+	}
+
+	added, removed, err := replicas.Update(addrs)
+	if err != nil {
+		log.Printf("[Discovery] Error updating the replica pool: %v", err)
+		return
+	}
+	if len(added) > 0 || len(removed) > 0 {
+		log.Printf("[Discovery] Replica pool: +%v -%v", added, removed)
+	}
+}
+
+// readyAddrs splits the reported addresses into those the pool may use and new
+// ones that have not caught up with the master yet. Addresses already in the
+// pool stay, a new one is only added once it passes the same gate as
+// WaitForReplicas, so reads never hit an index that is still building.
+func readyAddrs(addrs []string) (ready, pending []string, err error) {
+	inPool := map[string]bool{}
+	for _, client := range replicas.Clients() {
+		inPool[client.Options().Addr] = true
+	}
+
+	var masterOffset, masterDocs int64
+	checked := false
+	for _, addr := range addrs {
+		if inPool[addr] {
+			ready = append(ready, addr)
+			continue
+		}
+		if !checked {
+			if masterOffset, masterDocs, err = masterProgress(); err != nil {
+				return nil, nil, err
+			}
+			checked = true
+		}
+
+		client := redis.NewClient(&redis.Options{Addr: addr})
+		status := readReplicaStatus(client)
+		client.Close()
+		if status.ready(masterOffset, masterDocs) {
+			ready = append(ready, addr)
+		} else {
+			pending = append(pending, addr)
+		}
+	}
+	return ready, pending, nil
+}
+
+func printTopologyChanges(changes []TopologyChange, start time.Time) {
+	fmt.Println("\n|===== Replica topology =====|")
+	if len(changes) == 0 {
+		fmt.Printf("No changes, %d replicas in the pool\n", len(replicas.Clients()))
+		return
+	}
+	for _, change := range changes {
+		fmt.Printf("+%-8v added %v, removed %v\n",
+			change.At.Sub(start).Round(time.Millisecond), change.Added, change.Removed)
+	}
+	fmt.Printf("%d replicas in the pool at the end of the run\n", len(replicas.Clients()))
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// replicaStatus is a snapshot of how far a replica is behind the master.
+type replicaStatus struct {
+	Addr            string
+	LinkStatus      string
+	ReplOffset      int64
+	NumDocs         int64
+	Indexing        bool
+	PercentIndexed  float64
+	IndexInfoFailed bool
+}
+
+func (s replicaStatus) ready(masterOffset, masterDocs int64) bool {
+	return s.LinkStatus == "up" &&
+		s.ReplOffset >= masterOffset &&
+		!s.IndexInfoFailed &&
+		!s.Indexing &&
+		s.PercentIndexed >= 1 &&
+		s.NumDocs >= masterDocs
+}
+
+// WaitForReplicas blocks until every replica is linked to the master, has replayed
+// the master's replication offset and has finished indexing the same number of
+// documents, and returns how long that took.
+func WaitForReplicas(timeout time.Duration) (time.Duration, error) {
+	fmt.Println("Waiting for replicas to sync and finish indexing...")
+	startTime := time.Now()
+	lastReport := time.Time{}
+
+	for {
+		masterOffset, masterDocs, err := masterProgress()
+		if err != nil {
+			return time.Since(startTime), err
+		}
+
+		allReady := true
+		statuses := make([]replicaStatus, 0, len(replicas.Clients()))
+		for _, client := range replicas.Clients() {
+			status := readReplicaStatus(client)
+			statuses = append(statuses, status)
+			if !status.ready(masterOffset, masterDocs) {
+				allReady = false
+			}
+		}
+
+		if allReady {
+			elapsed := time.Since(startTime)
+			fmt.Printf("All %d replicas synced and indexed %d documents after %v\n",
+				len(statuses), masterDocs, elapsed.Round(time.Millisecond))
+			return elapsed, nil
+		}
+
+		if time.Since(lastReport) >= time.Second*5 {
+			lastReport = time.Now()
+			for _, s := range statuses {
+				fmt.Printf("  %s: link %s, offset %d/%d, docs %d/%d, indexed %.0f%%\n",
+					s.Addr, s.LinkStatus, s.ReplOffset, masterOffset, s.NumDocs, masterDocs, s.PercentIndexed*100)
+			}
+		}
+
+		if time.Since(startTime) > timeout {
+			return time.Since(startTime), fmt.Errorf("replicas not ready after %v", timeout)
+		}
+		time.Sleep(time.Millisecond * 500)
+	}
+}
+
+// masterProgress returns the master's replication offset and indexed document
+// count, the point a replica has to reach before it serves reads.
+func masterProgress() (offset, docs int64, err error) {
+	masterInfo, err := replicationInfo(rdbMaster)
+	if err != nil {
+		return 0, 0, fmt.Errorf("reading master replication info: %w", err)
+	}
+	offset, _ = strconv.ParseInt(masterInfo["master_repl_offset"], 10, 64)
+
+	masterIndex, err := ftInfo(rdbMaster, indexAlias)
+	if err != nil {
+		return 0, 0, fmt.Errorf("reading master index info: %w", err)
+	}
+	return offset, infoInt(masterIndex, "num_docs"), nil
+}
+
+func readReplicaStatus(client *redis.Client) replicaStatus {
+	status := replicaStatus{Addr: client.Options().Addr, LinkStatus: "unknown"}
+
+	if info, err := replicationInfo(client); err == nil {
+		if link, ok := info["master_link_status"]; ok {
+			status.LinkStatus = link
+		}
+		status.ReplOffset, _ = strconv.ParseInt(info["slave_repl_offset"], 10, 64)
+	}
+
+	index, err := ftInfo(client, indexAlias)
+	if err != nil {
+		status.IndexInfoFailed = true
+		return status
+	}
+	status.NumDocs = infoInt(index, "num_docs")
+	status.Indexing = infoInt(index, "indexing") != 0
+	status.PercentIndexed = infoFloat(index, "percent_indexed")
+
+	return status
+}
+
+// replicationInfo parses the "key:value" lines of INFO replication.
+func replicationInfo(client *redis.Client) (map[string]string, error) {
+	raw, err := client.Info(ctx, "replication").Result()
+	if err != nil {
+		return nil, err
+	}
+
+	info := map[string]string{}
+	for _, line := range strings.Split(raw, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if ok {
+			info[key] = value
+		}
+	}
+	return info, nil
+}
+
+// ftInfo returns the top-level FT.INFO fields for both RESP2 (flat key/value
+// array) and RESP3 (map) replies.
+func ftInfo(client *redis.Client, index string) (map[string]interface{}, error) {
+	reply, err := client.Do(ctx, "FT.INFO", index).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	info := map[string]interface{}{}
+	switch r := reply.(type) {
+	case []interface{}:
+		for i := 0; i+1 < len(r); i += 2 {
+			if key, ok := r[i].(string); ok {
+				info[key] = r[i+1]
+			}
+		}
+	case map[interface{}]interface{}:
+		for k, v := range r {
+			if key, ok := k.(string); ok {
+				info[key] = v
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unexpected FT.INFO reply %T", reply)
+	}
+	return info, nil
+}
+
+func infoInt(info map[string]interface{}, key string) int64 {
+	switch v := info[key].(type) {
+	case int64:
+		return v
+	case float64:
+		return int64(v)
+	case string:
+		n, _ := strconv.ParseFloat(v, 64)
+		return int64(n)
+	}
+	return 0
+}
+
+func infoFloat(info map[string]interface{}, key string) float64 {
+	switch v := info[key].(type) {
+	case int64:
+		return float64(v)
+	case float64:
+		return v
+	case string:
+		n, _ := strconv.ParseFloat(v, 64)
+		return n
+	}
+	return 0
+}
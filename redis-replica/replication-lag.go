@@ -0,0 +1,87 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const replicationProbeKey = "bench:replication-probe"
+
+// ReplicationLagProbe periodically writes a timestamp to the master and measures
+// how long it takes until every replica returns it.
+type ReplicationLagProbe struct {
+	stop    chan struct{}
+	done    chan struct{}
+	mu      sync.Mutex
+	samples []time.Duration
+}
+
+func StartReplicationLagProbe(interval time.Duration) *ReplicationLagProbe {
+	p := &ReplicationLagProbe{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+
+	go func() {
+		defer close(p.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+				p.measure()
+			}
+		}
+	}()
+
+	return p
+}
+
+func (p *ReplicationLagProbe) measure() {
+	written := time.Now()
+	value := strconv.FormatInt(written.UnixNano(), 10)
+	if err := rdbMaster.Set(ctx, replicationProbeKey, value, 0).Err(); err != nil {
+		log.Printf("Replication probe: error writing to master: %v", err)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, client := range replicas.Clients() {
+		wg.Add(1)
+		go func(client *redis.Client) {
+			defer wg.Done()
+			deadline := written.Add(time.Second * 10)
+			for time.Now().Before(deadline) {
+				if got, err := client.Get(ctx, replicationProbeKey).Result(); err == nil && got >= value {
+					p.mu.Lock()
+					p.samples = append(p.samples, time.Since(written))
+					p.mu.Unlock()
+					return
+				}
+				time.Sleep(time.Millisecond)
+			}
+			log.Printf("Replication probe: %s did not catch up within 10s", client.Options().Addr)
+			p.mu.Lock()
+			p.samples = append(p.samples, time.Since(written))
+			p.mu.Unlock()
+		}(client)
+	}
+	wg.Wait()
+}
+
+// Stop ends the probe and returns the lag observed across all replicas.
+func (p *ReplicationLagProbe) Stop() LatencySummary {
+	close(p.stop)
+	<-p.done
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return summarizeLatencies(p.samples)
+}
@@ -1,9 +1,16 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
 )
 
 type Location struct {
@@ -11,7 +18,11 @@ type Location struct {
 	Long float64
 }
 
-var ActiveTariffs []string = []string{"start", "camfort|camfort+", "business"}
+// Queries go through the alias, which points to the current index_vN
+const indexAlias = "index"
+
+// Each entry is a single tariff, "|" is the tag separator and cannot be part of a value
+var ActiveTariffs []string = []string{"start", "camfort", "camfort+", "business"}
 
 type Driver struct {
 	Id              int64
@@ -20,67 +31,336 @@ type Driver struct {
 	ActiveTariffs   []string
 	Score           int64
 	Charge          int64
-	Active          bool
-	LastUpdatedTime string
+	Status          DriverStatus
+	LastUpdatedTime string // unix milliseconds
+}
+
+// luaUpsertDriver writes a driver only if the update is newer than the stored
+// last_updated_time, so delayed or duplicated pings cannot move a driver back in
+// time, and records the ping in the heartbeat set. a holds the arguments:
+//
+//	1 storage mode
+//	2 update time, "" for updates that are not pings (no ordering check or heartbeat)
+//	3 driver id
+//	4 write mode: "1" reset the status, "0" upsert, "p" partial update of an existing driver
+//	5 legacy active value for free, "" when the active schema has no active field
+//	6... hashes: the number of field pairs, the field pairs, then the status pairs
+//	     JSON: the document (the partial one for "p"), then the document without status
+//
+// A ping from an existing driver the sweeper set offline brings it back to free.
+// It returns 1 if the update was applied.
+const luaUpsertDriver = luaStatusFunctions + `
+local function upsert_driver(key, heartbeats, a)
+	local existed = redis.call('EXISTS', key) == 1
+	if a[4] == 'p' and not existed then
+		return 0
+	end
+
+	if a[2] ~= '' and a[4] ~= '1' then
+		local stored
+		if a[1] == 'json' then
+			local raw = redis.call('JSON.GET', key, '$.last_updated_time')
+			if raw then
+				stored = cjson.decode(raw)[1]
+			end
+		else
+			stored = redis.call('HGET', key, 'last_updated_time')
+		end
+		if stored and tonumber(stored) and tonumber(stored) >= tonumber(a[2]) then
+			return 0
+		end
+	end
+
+	if a[1] == 'json' then
+		if a[4] == '1' then
+			redis.call('JSON.SET', key, '$', a[6])
+		elseif a[4] == 'p' then
+			redis.call('JSON.MERGE', key, '$', a[6])
+		elseif not redis.call('JSON.SET', key, '$', a[6], 'NX') then
+			-- Existing document, merge everything but the status
+			redis.call('JSON.MERGE', key, '$', a[7])
+		end
+	else
+		local n = tonumber(a[6])
+		local fields = {}
+		for i = 7, 6 + 2 * n do
+			table.insert(fields, a[i])
+		end
+		redis.call('HSET', key, unpack(fields))
+		for i = 7 + 2 * n, #a, 2 do
+			if a[4] == '1' then
+				redis.call('HSET', key, a[i], a[i + 1])
+			else
+				redis.call('HSETNX', key, a[i], a[i + 1])
+			end
+		end
+	end
+
+	if a[2] ~= '' and a[4] ~= '1' and existed and get_status(key, a[1]) == 'offline' then
+		set_status(key, a[1], 'free', a[5])
+	end
+
+	if a[2] ~= '' then
+		redis.call('ZADD', heartbeats, a[2], a[3])
+	end
+	return 1
+end
+`
+
+// upsertDriverScript runs luaUpsertDriver for one driver. KEYS are the driver
+// key and the heartbeat set, ARGV the arguments.
+var upsertDriverScript = redis.NewScript(luaUpsertDriver + `
+return upsert_driver(KEYS[1], KEYS[2], ARGV)
+`)
+
+// Write modes of upsertDriverScript
+const (
+	writeModeReset   = "1"
+	writeModeUpsert  = "0"
+	writeModePartial = "p"
+)
+
+// driverWrite is one queued call of upsertDriverScript.
+type driverWrite struct {
+	keys []string
+	args []interface{}
+}
+
+func newDriverWrite(id int64, updateTime, mode string) driverWrite {
+	return driverWrite{
+		keys: []string{driverKey(id), heartbeatKey},
+		args: []interface{}{storageMode, updateTime, id, mode, legacyActive(StatusFree)},
+	}
+}
+
+// hashFields appends the field pairs in the layout upsertDriverScript expects.
+func (w driverWrite) hashFields(fields []interface{}, status []interface{}) driverWrite {
+	w.args = append(w.args, len(fields)/2)
+	w.args = append(w.args, fields...)
+	w.args = append(w.args, status...)
+	return w
 }
 
-// Create driver if not exists if exists update it.
+// Create driver if not exists if exists update it. Updates that are not newer
+// than the stored last_updated_time are skipped. The status of an existing
+// driver is left alone, it only changes through TransitionDriver.
 func UpsertDrivers(drivers []Driver) error {
-	pipe := rdbMaster.Pipeline() // batch all commands
+	_, err := writeDrivers(drivers, false)
+	return err
+}
+
+// UpsertDriversApplied is UpsertDrivers reporting for every driver whether its
+// update was applied or rejected as out of order.
+func UpsertDriversApplied(drivers []Driver) ([]bool, error) {
+	return writeDrivers(drivers, false)
+}
+
+// RegisterDrivers writes the drivers including their status, overriding the
+// state machine and the ordering check. Seeding uses it to reset the fleet.
+// Reserved drivers get a hold like any reservation, so they are freed again
+// once it expires.
+func RegisterDrivers(drivers []Driver) error {
+	if _, err := writeDrivers(drivers, true); err != nil {
+		return err
+	}
+	return resetHolds(drivers)
+}
+
+func writeDrivers(drivers []Driver, resetStatus bool) ([]bool, error) {
+	writes, err := buildDriverWrites(drivers, resetStatus)
+	if err != nil {
+		return nil, err
+	}
+	return execDriverWrites(writes)
+}
+
+func buildDriverWrites(drivers []Driver, resetStatus bool) ([]driverWrite, error) {
+	mode := writeModeUpsert
+	if resetStatus {
+		mode = writeModeReset
+	}
 
+	writes := make([]driverWrite, 0, len(drivers))
 	for _, in := range drivers {
-		key := fmt.Sprintf("driver:%d", in.Id)
+		w := newDriverWrite(in.Id, in.LastUpdatedTime, mode)
 
-		fields := map[string]interface{}{
-			"driver_id":            in.Id,
-			"location":             fmt.Sprintf("%f,%f", in.Location.Lat, in.Location.Long),
-			"geo_hash":             in.GeoHash,
-			"active_tariffs":       strings.Join(in.ActiveTariffs, "|"),
-			"score":                in.Score,
-			"active":               fmt.Sprintf("%t", in.Active),
-			"phone_charge_percent": in.Charge,
-			"last_updated_time":    in.LastUpdatedTime,
+		if storageMode == storageJSON {
+			doc, err := encodeDriverDocument(in)
+			if err != nil {
+				return nil, err
+			}
+			profile := in
+			profile.Status = ""
+			update, err := encodeDriverDocument(profile)
+			if err != nil {
+				return nil, err
+			}
+			w.args = append(w.args, doc, update)
+			writes = append(writes, w)
+			continue
 		}
 
-		pipe.HSet(ctx, key, fields)
+		fields := []interface{}{
+			"driver_id", in.Id,
+			"location", fmt.Sprintf("%f,%f", in.Location.Lat, in.Location.Long),
+			"geo_hash", in.GeoHash,
+			"active_tariffs", strings.Join(in.ActiveTariffs, "|"),
+			"score", in.Score,
+			"phone_charge_percent", in.Charge,
+			"last_updated_time", in.LastUpdatedTime,
+		}
+		status := []interface{}{"status", string(in.Status)}
+		if active := legacyActive(in.Status); active != "" {
+			status = append(status, "active", active)
+		}
+		writes = append(writes, w.hashFields(fields, status))
 	}
+	return writes, nil
+}
+
+// LocationUpdate is a location ping, the bulk of real write traffic.
+type LocationUpdate struct {
+	Id              int64
+	Location        Location
+	GeoHash         string
+	LastUpdatedTime string // unix milliseconds
+}
+
+// UpdateDriverLocations writes only location, geo_hash and last_updated_time of
+// existing drivers. Like UpsertDrivers it skips pings that are not newer than
+// the stored one, the result tells for every update whether it was applied.
+func UpdateDriverLocations(updates []LocationUpdate) ([]bool, error) {
+	writes := make([]driverWrite, 0, len(updates))
+	for _, u := range updates {
+		w := newDriverWrite(u.Id, u.LastUpdatedTime, writeModePartial)
+		location := fmt.Sprintf("%f,%f", u.Location.Lat, u.Location.Long)
+
+		if storageMode == storageJSON {
+			lastUpdated, _ := strconv.ParseInt(u.LastUpdatedTime, 10, 64)
+			doc, err := json.Marshal(map[string]interface{}{
+				"location":          location,
+				"geo_hash":          u.GeoHash,
+				"last_updated_time": lastUpdated,
+			})
+			if err != nil {
+				return nil, err
+			}
+			w.args = append(w.args, string(doc))
+			writes = append(writes, w)
+			continue
+		}
 
-	// Execute all queued commands in one round trip
-	_, err := pipe.Exec(ctx)
+		writes = append(writes, w.hashFields([]interface{}{
+			"location", location,
+			"geo_hash", u.GeoHash,
+			"last_updated_time", u.LastUpdatedTime,
+		}, nil))
+	}
+
+	return execDriverWrites(writes)
+}
+
+// ProfileUpdate changes what a driver offers, not where it is.
+type ProfileUpdate struct {
+	Id            int64
+	ActiveTariffs []string
+	Score         int64
+}
+
+// UpdateDriverProfiles writes only active_tariffs and score of existing drivers.
+// Profile changes are not pings, so they neither refresh the heartbeat nor take
+// part in the ordering check.
+func UpdateDriverProfiles(updates []ProfileUpdate) error {
+	writes := make([]driverWrite, 0, len(updates))
+	for _, u := range updates {
+		w := newDriverWrite(u.Id, "", writeModePartial)
+
+		if storageMode == storageJSON {
+			doc, err := json.Marshal(map[string]interface{}{
+				"active_tariffs": u.ActiveTariffs,
+				"score":          u.Score,
+			})
+			if err != nil {
+				return err
+			}
+			w.args = append(w.args, string(doc))
+			writes = append(writes, w)
+			continue
+		}
+
+		writes = append(writes, w.hashFields([]interface{}{
+			"active_tariffs", strings.Join(u.ActiveTariffs, "|"),
+			"score", u.Score,
+		}, nil))
+	}
+
+	_, err := execDriverWrites(writes)
 	return err
 }
 
+// execDriverWrites runs the writes with the configured writeStrategy.
+func execDriverWrites(writes []driverWrite) ([]bool, error) {
+	return execDriverWritesWith(writeStrategy, writes)
+}
+
+// active is a TAG holding "true"/"false" unless the schema indexes it as NUMERIC
+func encodeActive(active bool) interface{} {
+	if activeFieldType("active") == "NUMERIC" {
+		if active {
+			return 1
+		}
+		return 0
+	}
+	return fmt.Sprintf("%t", active)
+}
+
+// GetDriver reads one driver from a replica. A missing driver is
+// ErrDriverNotFound, a malformed one is handled according to parseMode.
 func GetDriver(id int64) (Driver, error) {
-	key := fmt.Sprintf("driver:%d", id)
+	return getDriver(replicas.Get(), id)
+}
+
+func getDriver(client *redis.Client, id int64) (Driver, error) {
+	key := driverKey(id)
+
+	if storageMode == storageJSON {
+		return getDriverJSON(client, key)
+	}
 
 	// Get all fields from the hash
-	result, err := replicas.Get().HGetAll(ctx, key).Result()
+	result, err := client.HGetAll(ctx, key).Result()
 	if err != nil {
 		return Driver{}, err
 	}
 
 	if len(result) == 0 {
-		return Driver{}, nil
+		return Driver{}, driverNotFound(key)
 	}
+	return parseDriverHash(key, result)
+}
 
-	// Parse the result into Driver struct
+// parseDriverHash converts the fields of a driver hash into a Driver, every
+// field that cannot be parsed is reported as a DriverParseError.
+func parseDriverHash(key string, result map[string]string) (Driver, error) {
 	driver := Driver{}
+	errs := []error{}
+	invalid := func(field string, err error) {
+		errs = append(errs, &DriverParseError{Key: key, Field: field, Value: result[field], Err: err})
+	}
 
 	if driverId, ok := result["driver_id"]; ok {
 		if id, err := strconv.ParseInt(driverId, 10, 64); err == nil {
 			driver.Id = id
+		} else {
+			invalid("driver_id", err)
 		}
 	}
 
 	if location, ok := result["location"]; ok {
-		parts := strings.Split(location, ",")
-		if len(parts) == 2 {
-			if lat, err := strconv.ParseFloat(parts[0], 64); err == nil {
-				driver.Location.Lat = lat
-			}
-			if lng, err := strconv.ParseFloat(parts[1], 64); err == nil {
-				driver.Location.Long = lng
-			}
+		if l, err := parseLocation(location); err == nil {
+			driver.Location = l
+		} else {
+			invalid("location", err)
 		}
 	}
 
@@ -95,99 +375,265 @@ func GetDriver(id int64) (Driver, error) {
 	if score, ok := result["score"]; ok {
 		if s, err := strconv.ParseInt(score, 10, 64); err == nil {
 			driver.Score = s
+		} else {
+			invalid("score", err)
 		}
 	}
 
-	if active, ok := result["active"]; ok {
-		driver.Active = active == "true"
+	if status, ok := result["status"]; ok {
+		if s, err := parseStatus(status); err == nil {
+			driver.Status = s
+		} else {
+			invalid("status", err)
+		}
+	} else if active, ok := result["active"]; ok {
+		// Written before statuses existed
+		driver.Status = StatusOffline
+		if active == "true" || active == "1" {
+			driver.Status = StatusFree
+		}
 	}
 
 	if charge, ok := result["phone_charge_percent"]; ok {
 		if c, err := strconv.ParseInt(charge, 10, 64); err == nil {
 			driver.Charge = c
+		} else {
+			invalid("phone_charge_percent", err)
 		}
 	}
 
 	if lastUpdated, ok := result["last_updated_time"]; ok {
-		driver.LastUpdatedTime = lastUpdated
+		if _, err := strconv.ParseInt(lastUpdated, 10, 64); err == nil {
+			driver.LastUpdatedTime = lastUpdated
+		} else {
+			invalid("last_updated_time", err)
+		}
 	}
 
-	return driver, nil
+	return parsedDriver(driver, errs)
 }
 
-// In response sort by driver_id field
-func GetDriverInRadius(location Location, radiusKm float64, limit int) ([]Driver, error) {
-	// Build the search query for geospatial search
-	query := fmt.Sprintf("@location:[%f %f %f km]", location.Long, location.Lat, radiusKm)
-
-	// Execute the search with sorting by driver_id
-	searchResult, err := replicas.Get().Do(ctx, "FT.SEARCH", "index", query, "SORTBY", "driver_id", "ASC", "LIMIT", 0, limit).Result()
+// parseLocation parses the "lat,long" format of the location field.
+func parseLocation(value string) (Location, error) {
+	parts := strings.Split(value, ",")
+	if len(parts) != 2 {
+		return Location{}, errors.New("expected lat,long")
+	}
+	lat, err := strconv.ParseFloat(parts[0], 64)
 	if err != nil {
-		return nil, err
+		return Location{}, err
 	}
+	lng, err := strconv.ParseFloat(parts[1], 64)
+	if err != nil {
+		return Location{}, err
+	}
+	return Location{Lat: lat, Long: lng}, nil
+}
 
-	// Parse the search result
-	results, ok := searchResult.([]interface{})
-	if !ok || len(results) < 2 {
-		return []Driver{}, nil
+// DriverLookup is the outcome of GetDrivers. Found keeps the order of the
+// requested ids, Missing lists the ids without a driver and Malformed those
+// that could not be parsed.
+type DriverLookup struct {
+	Found     []Driver
+	Missing   []int64
+	Malformed []int64
+}
+
+// GetDrivers fetches many drivers in one pipelined round trip. With
+// batchGetSplitReplicas, lookups of more than batchGetChunkSize ids are split
+// into chunks that are fetched from several replicas in parallel. Malformed
+// drivers make it return ErrMalformedDriver; in lenient mode the lookup is
+// returned as well and holds the drivers that could be decoded.
+func GetDrivers(ids []int64) (DriverLookup, error) {
+	chunkSize := len(ids)
+	if batchGetSplitReplicas {
+		chunkSize = batchGetChunkSize
+	}
+	if chunkSize == 0 {
+		return DriverLookup{}, nil
 	}
 
-	// Skip the first element (total count) and process driver data
-	driverData := results[1:]
-	drivers := make([]Driver, 0, len(driverData))
+	chunks := make([][]int64, 0, len(ids)/chunkSize+1)
+	for from := 0; from < len(ids); from += chunkSize {
+		chunks = append(chunks, ids[from:min(from+chunkSize, len(ids))])
+	}
 
-	for i := 0; i < len(driverData); i += 2 {
-		if i+1 >= len(driverData) {
-			break
-		}
+	results := make([][]driverResult, len(chunks))
+	errs := make([]error, len(chunks))
+	var wg sync.WaitGroup
+	for i, chunk := range chunks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = getDriversFrom(replicas.Get(), chunk)
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return DriverLookup{}, err
+	}
 
-		// Get the driver ID from the key
-		key, ok := driverData[i].(string)
-		if !ok {
-			continue
+	lookup := DriverLookup{Found: make([]Driver, 0, len(ids))}
+	malformed := []error{}
+	for i, chunk := range chunks {
+		for j, id := range chunk {
+			r := results[i][j]
+			switch {
+			case errors.Is(r.err, ErrDriverNotFound):
+				lookup.Missing = append(lookup.Missing, id)
+				continue
+			case r.err != nil:
+				lookup.Malformed = append(lookup.Malformed, id)
+				malformed = append(malformed, r.err)
+			}
+			if usableDriver(r.err) {
+				lookup.Found = append(lookup.Found, r.driver)
+			}
 		}
+	}
 
-		// Extract driver ID from key (format: driver:123)
-		keyParts := strings.Split(key, ":")
-		if len(keyParts) != 2 {
-			continue
+	err := errors.Join(malformed...)
+	if err != nil && parseMode == parseStrict {
+		return DriverLookup{}, err
+	}
+	return lookup, err
+}
+
+type driverResult struct {
+	driver Driver
+	err    error // ErrDriverNotFound or a parse error
+}
+
+// getDriversFrom reads the drivers from one client. Only a failed round trip is
+// returned as error, missing and malformed drivers are reported per id.
+func getDriversFrom(client *redis.Client, ids []int64) ([]driverResult, error) {
+	pipe := client.Pipeline()
+	cmds := make([]redis.Cmder, len(ids))
+	for i, id := range ids {
+		if storageMode == storageJSON {
+			cmds[i] = pipe.Do(ctx, "JSON.GET", driverKey(id))
+		} else {
+			cmds[i] = pipe.HGetAll(ctx, driverKey(id))
 		}
+	}
+	// A missing JSON document replies nil, which is not a failure here
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
 
-		driverId, err := strconv.ParseInt(keyParts[1], 10, 64)
-		if err != nil {
-			continue
+	results := make([]driverResult, len(ids))
+	for i, cmd := range cmds {
+		key := driverKey(ids[i])
+		switch cmd := cmd.(type) {
+		case *redis.MapStringStringCmd:
+			if fields := cmd.Val(); len(fields) > 0 {
+				results[i].driver, results[i].err = parseDriverHash(key, fields)
+			} else {
+				results[i].err = driverNotFound(key)
+			}
+		case *redis.Cmd:
+			raw, err := cmd.Text()
+			if errors.Is(err, redis.Nil) {
+				results[i].err = driverNotFound(key)
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			results[i].driver, results[i].err = decodeDriverDocument(key, raw)
 		}
+	}
+	return results, nil
+}
 
-		// Get the driver details
-		driver, err := GetDriver(driverId)
-		if err != nil {
-			continue
+// DriverFilter narrows dispatch candidates, zero values disable a filter. Both
+// filters need the fields indexed, which schema v2 does.
+type DriverFilter struct {
+	MinCharge int64         // minimum phone_charge_percent
+	MaxAge    time.Duration // maximum time since last_updated_time
+}
+
+func (f DriverFilter) clauses() ([]Query, error) {
+	clauses := []Query{}
+	if f.MinCharge > 0 {
+		if !activeFieldIndexed("phone_charge_percent") {
+			return nil, errors.New("phone_charge_percent is not indexed, migrate to schema v2")
 		}
+		clauses = append(clauses, NumericRange("phone_charge_percent", float64(f.MinCharge), math.Inf(1)))
+	}
+	if f.MaxAge > 0 {
+		if !activeFieldIndexed("last_updated_time") {
+			return nil, errors.New("last_updated_time is not indexed, migrate to schema v2")
+		}
+		oldest := time.Now().Add(-f.MaxAge).UnixMilli()
+		clauses = append(clauses, NumericRange("last_updated_time", float64(oldest), math.Inf(1)))
+	}
+	return clauses, nil
+}
+
+// In response sort by driver_id field
+func GetDriverInRadius(location Location, radiusKm float64, limit int, filter DriverFilter) ([]Driver, error) {
+	filters, err := filter.clauses()
+	if err != nil {
+		return nil, err
+	}
+
+	// Build the search query for geospatial search
+	query := And(append([]Query{Geo("location", location, radiusKm)}, filters...)...).String()
 
-		drivers = append(drivers, driver)
+	// Execute the search with sorting by driver_id
+	searchResult, err := replicas.Get().Do(ctx, "FT.SEARCH", searchIndex(), query, "SORTBY", "driver_id", "ASC", "LIMIT", 0, limit).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse the search result
+	results, ok := searchResult.([]interface{})
+	if !ok || len(results) < 2 {
+		return []Driver{}, nil
 	}
 
-	return drivers, nil
+	return driversFromSearch(results[1:])
 }
 
 // In response sort by score field
-func GetDriverForOrder(geoHash string, tariffs []string, limit int) ([]Driver, error) {
+func GetDriverForOrder(geoHash string, tariffs []string, limit int, filter DriverFilter) ([]Driver, error) {
+	if len(tariffs) == 0 {
+		return nil, errors.New("at least one tariff is required")
+	}
+	filters, err := filter.clauses()
+	if err != nil {
+		return nil, err
+	}
+
 	// Build the search query
-	var queryParts []string
+	var clauses []Query
 
 	// Add geo_hash filter if provided
 	if geoHash != "" {
-		queryParts = append(queryParts, fmt.Sprintf("@geo_hash:%s*", geoHash))
+		if activeFieldType("geo_hash") == "TAG" {
+			clauses = append(clauses, TagPrefix("geo_hash", geoHash))
+		} else {
+			clauses = append(clauses, TextPrefix("geo_hash", geoHash))
+		}
 	}
 
-	// Add active filter
-	queryParts = append(queryParts, "@active:{true}")
-	queryParts = append(queryParts, fmt.Sprintf("@active_tariffs:{%s}", strings.Join(tariffs, "|")))
+	// Only free drivers can take an order, indexes before v3 only know active
+	switch {
+	case activeFieldType("status") == "TAG":
+		clauses = append(clauses, Tag("status", string(StatusFree)))
+	case activeFieldType("active") == "NUMERIC":
+		clauses = append(clauses, NumericRange("active", 1, 1))
+	default:
+		clauses = append(clauses, Tag("active", "true"))
+	}
+	clauses = append(clauses, Tag("active_tariffs", tariffs...))
+	clauses = append(clauses, filters...)
 
-	query := strings.Join(queryParts, " ")
+	query := And(clauses...).String()
 
 	// Execute the search with sorting by score (descending for best scores first)
-	searchResult, err := replicas.Get().Do(ctx, "FT.SEARCH", "index", query, "SORTBY", "score", "DESC", "LIMIT", 0, limit).Result()
+	searchResult, err := replicas.Get().Do(ctx, "FT.SEARCH", searchIndex(), query, "SORTBY", "score", "DESC", "LIMIT", 0, limit).Result()
 	if err != nil {
 		return nil, err
 	}
@@ -198,40 +644,49 @@ func GetDriverForOrder(geoHash string, tariffs []string, limit int) ([]Driver, e
 		return []Driver{}, nil
 	}
 
-	// Skip the first element (total count) and process driver data
-	driverData := results[1:]
-	drivers := make([]Driver, 0, len(driverData))
-
-	for i := 0; i < len(driverData); i += 2 {
-		if i+1 >= len(driverData) {
-			break
-		}
-
-		// Get the driver ID from the key
-		key, ok := driverData[i].(string)
-		if !ok {
-			continue
-		}
+	return driversFromSearch(results[1:])
+}
 
-		// Extract driver ID from key (format: driver:123)
+// driversFromSearch loads the drivers of an FT.SEARCH reply without the total
+// count. Drivers deleted since the search are left out. Malformed keys and
+// drivers make it return ErrMalformedDriver; in lenient mode together with the
+// drivers that could be decoded.
+func driversFromSearch(driverData []interface{}) ([]Driver, error) {
+	drivers := make([]Driver, 0, len(driverData)/2)
+	malformed := []error{}
+
+	for i := 0; i+1 < len(driverData); i += 2 {
+		// Get the driver ID from the key (format: driver:123 or driver_json:123)
+		key, _ := driverData[i].(string)
 		keyParts := strings.Split(key, ":")
 		if len(keyParts) != 2 {
+			malformed = append(malformed, &DriverParseError{Key: key, Field: "key", Value: key, Err: errors.New("expected prefix:id")})
 			continue
 		}
-
 		driverId, err := strconv.ParseInt(keyParts[1], 10, 64)
 		if err != nil {
+			malformed = append(malformed, &DriverParseError{Key: key, Field: "key", Value: key, Err: err})
 			continue
 		}
 
 		// Get the driver details
 		driver, err := GetDriver(driverId)
-		if err != nil {
+		if errors.Is(err, ErrDriverNotFound) {
 			continue
 		}
-
-		drivers = append(drivers, driver)
+		if errors.Is(err, ErrMalformedDriver) {
+			malformed = append(malformed, err)
+		} else if err != nil {
+			return nil, err
+		}
+		if usableDriver(err) {
+			drivers = append(drivers, driver)
+		}
 	}
 
-	return drivers, nil
+	err := errors.Join(malformed...)
+	if err != nil && parseMode == parseStrict {
+		return nil, err
+	}
+	return drivers, err
 }
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+type saturationStep struct {
+	Result LoadResult
+	Passed bool
+	Reason string
+}
+
+// runSaturationSearch ramps the offered load for the given workload ("mix" for all
+// four) until the latency SLO or the error budget breaks, then binary-searches the
+// highest rate that still holds them. If the start rate already breaks the SLO,
+// the rate is halved until a step passes before the binary search.
+func runSaturationSearch(workloadName string) error {
+	workloads, err := findWorkloads(workloadName)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Searching maximum sustainable throughput for %q workload\n", workloadName)
+	fmt.Printf("SLO: p99 < %v, error ratio <= %.2f%%, achieved >= %.0f%% of offered\n",
+		saturationP99Target, saturationErrorBudget*100, saturationMinAchievedRatio*100)
+
+	steps := []saturationStep{}
+	probe := func(opsPerMinute int) bool {
+		result := runPacedLoad(workloads, opsPerMinute, saturationGoroutinesCount, saturationStepDuration)
+		passed, reason := checkSaturationSLO(result)
+		steps = append(steps, saturationStep{Result: result, Passed: passed, Reason: reason})
+		fmt.Printf("  offered %9d ops/min -> achieved %9d ops/min, p99 %v, errors %.2f%% [%s]\n",
+			result.OfferedOpsPerMinute, result.AchievedOpsPerMinute, result.Latency.P99,
+			result.ErrorRatio()*100, reason)
+
+		// Let Redis drain queued work before the next step
+		time.Sleep(time.Second * 2)
+		return passed
+	}
+
+	// Ramp phase: double the offered load until the SLO breaks
+	lastGood, firstBad := 0, 0
+	for rate := saturationStartRate; ; rate = min(rate*2, saturationMaxRate) {
+		if !probe(rate) {
+			firstBad = rate
+			break
+		}
+		lastGood = rate
+		if rate >= saturationMaxRate {
+			break
+		}
+	}
+
+	// Back-off phase: the start rate is already too high, halve it until a step passes
+	for rate := firstBad / 2; lastGood == 0 && rate >= saturationMinRate; rate /= 2 {
+		if probe(rate) {
+			lastGood = rate
+		} else {
+			firstBad = rate
+		}
+	}
+
+	// Binary search between the last passing and the first failing rate
+	if lastGood > 0 && firstBad > 0 {
+		for float64(firstBad-lastGood)/float64(lastGood) > saturationPrecision {
+			mid := (lastGood + firstBad) / 2
+			if probe(mid) {
+				lastGood = mid
+			} else {
+				firstBad = mid
+			}
+		}
+	}
+
+	printSaturationReport(workloadName, steps, lastGood, firstBad)
+	return nil
+}
+
+func checkSaturationSLO(result LoadResult) (bool, string) {
+	if result.Latency.P99 >= saturationP99Target {
+		return false, "p99 over target"
+	}
+	if result.ErrorRatio() > saturationErrorBudget {
+		return false, "error budget exceeded"
+	}
+	if float64(result.AchievedOpsPerMinute) < float64(result.OfferedOpsPerMinute)*saturationMinAchievedRatio {
+		return false, "cannot keep up with offered load"
+	}
+	return true, "ok"
+}
+
+func printSaturationReport(workloadName string, steps []saturationStep, lastGood, firstBad int) {
+	fmt.Println("\n|===== Saturation search =====|")
+	fmt.Printf("Topology: master %s, %d replicas, %d goroutines\n", masterAddr, len(replicas.Clients()), saturationGoroutinesCount)
+	fmt.Printf("%-12s %-12s %-10s %-10s %-10s %-8s %s\n", "Offered", "Achieved", "p50", "p95", "p99", "Errors", "Result")
+	for _, step := range steps {
+		r := step.Result
+		fmt.Printf("%-12d %-12d %-10v %-10v %-10v %-8s %s\n",
+			r.OfferedOpsPerMinute, r.AchievedOpsPerMinute,
+			r.Latency.P50.Round(time.Microsecond), r.Latency.P95.Round(time.Microsecond), r.Latency.P99.Round(time.Microsecond),
+			fmt.Sprintf("%.2f%%", r.ErrorRatio()*100), step.Reason)
+	}
+
+	switch {
+	case lastGood == 0:
+		fmt.Printf("\nWorkload %q violates the SLO even at %d ops/min, lower saturationMinRate\n", workloadName, firstBad)
+	case firstBad == 0:
+		fmt.Printf("\nWorkload %q holds the SLO up to saturationMaxRate (%d ops/min), knee point not reached\n", workloadName, lastGood)
+	default:
+		fmt.Printf("\nKnee point for %q: %d ops/min (first violation at %d ops/min)\n", workloadName, lastGood, firstBad)
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const experimentIndexPrefix = indexAlias + "_exp_"
+
+// SchemaVariant is an index design evaluated by `go run . experiment`.
+type SchemaVariant struct {
+	Name   string
+	Schema []SchemaField
+}
+
+// schemaVariants are run in order, each one against a freshly seeded dataset.
+// They vary the latest schema version, so the baseline is what production runs.
+var schemaVariants = variantsOf(latestIndexVersion().Schema)
+
+func variantsOf(base []SchemaField) []SchemaVariant {
+	return []SchemaVariant{
+		{Name: "baseline", Schema: base},
+		{Name: "geo_hash-tag", Schema: withFields(base,
+			SchemaField{Name: "geo_hash", Type: "TAG"},
+		)},
+		{Name: "no-sortable", Schema: withFields(base,
+			SchemaField{Name: "driver_id", Type: "NUMERIC"},
+			SchemaField{Name: "score", Type: "NUMERIC"},
+		)},
+		{Name: "noindex-charge-time", Schema: withFields(base,
+			SchemaField{Name: "phone_charge_percent", Type: "NUMERIC", NoIndex: true},
+			SchemaField{Name: "last_updated_time", Type: "NUMERIC", NoIndex: true},
+		)},
+		{Name: "active-numeric", Schema: append(withoutFields(base, "status", "active"),
+			SchemaField{Name: "active", Type: "NUMERIC"},
+		)},
+	}
+}
+
+type experimentResult struct {
+	Variant      string
+	SeedPerSec   float64
+	Load         LoadResult
+	IndexMemMB   float64
+	SortableMB   float64
+	InvertedMB   float64
+	IndexingTime time.Duration
+}
+
+// runSchemaExperiments re-creates the index for every schema variant, reseeds the
+// drivers so fields are encoded for that variant, runs the same workload and
+// prints throughput, latency and FT.INFO memory side by side. The schema version
+// that was live is restored afterwards, also when a variant fails.
+func runSchemaExperiments(workloadName string) (err error) {
+	workloads, err := findWorkloads(workloadName)
+	if err != nil {
+		return err
+	}
+	opsPerMinute := 0
+	for _, w := range workloads {
+		opsPerMinute += w.Weight
+	}
+
+	// Leftovers of an interrupted run would index every driver write
+	if err := dropExperimentIndexes(); err != nil {
+		return err
+	}
+
+	// The experiment indexes replace the live one for the duration of the run,
+	// remember its version so exactly that one is restored
+	current, err := resolveAlias(rdbMaster)
+	if err != nil {
+		return fmt.Errorf("resolving alias '%s': %w", indexAlias, err)
+	}
+	version, err := parseIndexVersion(current)
+	if err != nil {
+		return err
+	}
+	live, ok := findIndexVersion(version)
+	if !ok {
+		return fmt.Errorf("alias '%s' points to unknown schema version %d", indexAlias, version)
+	}
+	if err := rdbMaster.Do(ctx, "FT.DROPINDEX", current).Err(); err != nil {
+		return fmt.Errorf("dropping %s: %w", current, err)
+	}
+
+	// A failed variant must not leave the alias on an experiment index
+	defer func() {
+		if err != nil {
+			err = errors.Join(err, dropExperimentIndexes(), restoreIndexVersion(live))
+		}
+	}()
+
+	results := []experimentResult{}
+	for _, variant := range schemaVariants {
+		fmt.Printf("\n[Experiment] Variant %q\n", variant.Name)
+		result, err := runSchemaVariant(variant, workloads, opsPerMinute)
+		if err != nil {
+			return fmt.Errorf("variant %s: %w", variant.Name, err)
+		}
+		results = append(results, result)
+	}
+
+	printExperimentReport(workloadName, opsPerMinute, results)
+	return restoreIndexVersion(live)
+}
+
+// restoreIndexVersion points the alias back to the schema version that was live
+// before the experiment and reseeds the drivers in its encoding. Moving to a
+// newer version is left to `migrate`.
+func restoreIndexVersion(v IndexVersion) error {
+	fmt.Printf("\n[Experiment] Restoring schema version %d...\n", v.Version)
+	if err := createVersionedIndex(v); err != nil {
+		return err
+	}
+	setActiveSchema(v.Schema)
+	if _, err := SeedDrivers(); err != nil {
+		return err
+	}
+	_, err := WaitForReplicas(replicaReadyTimeout)
+	return err
+}
+
+func experimentIndexName(variant SchemaVariant) string {
+	return experimentIndexPrefix + strings.ReplaceAll(variant.Name, "-", "_")
+}
+
+// dropExperimentIndexes removes every experiment index left on the master.
+func dropExperimentIndexes() error {
+	indexes, err := rdbMaster.Do(ctx, "FT._LIST").StringSlice()
+	if err != nil {
+		return fmt.Errorf("listing indexes on master: %w", err)
+	}
+	for _, index := range indexes {
+		if !strings.HasPrefix(index, experimentIndexPrefix) {
+			continue
+		}
+		if err := rdbMaster.Do(ctx, "FT.DROPINDEX", index).Err(); err != nil {
+			return fmt.Errorf("dropping %s: %w", index, err)
+		}
+	}
+	return nil
+}
+
+func runSchemaVariant(variant SchemaVariant, workloads []Workload, opsPerMinute int) (experimentResult, error) {
+	result := experimentResult{Variant: variant.Name}
+	index := experimentIndexName(variant)
+
+	if err := rdbMaster.Do(ctx, createIndexArgs(index, variant.Schema)...).Err(); err != nil {
+		return result, fmt.Errorf("creating %s: %w", index, err)
+	}
+	if err := rdbMaster.Do(ctx, "FT.ALIASUPDATE", indexAlias, index).Err(); err != nil {
+		return result, fmt.Errorf("pointing alias to %s: %w", index, err)
+	}
+	setActiveSchema(variant.Schema)
+
+	// Reseed so values like active are written in this variant's encoding
+	seedPerSec, err := SeedDrivers()
+	if err != nil {
+		return result, err
+	}
+	result.SeedPerSec = seedPerSec
+
+	indexingStart := time.Now()
+	if _, err := WaitForReplicas(replicaReadyTimeout); err != nil {
+		return result, err
+	}
+	result.IndexingTime = time.Since(indexingStart)
+
+	result.Load = runPacedLoad(workloads, opsPerMinute, readGoroutinesCount, experimentStepDuration)
+
+	info, err := ftInfo(rdbMaster, index)
+	if err != nil {
+		return result, fmt.Errorf("reading FT.INFO %s: %w", index, err)
+	}
+	result.IndexMemMB = indexMemoryMB(info)
+	result.SortableMB = infoFloat(info, "sortable_values_size_mb")
+	result.InvertedMB = infoFloat(info, "inverted_sz_mb")
+
+	// Drop the index but keep the drivers for the next variant
+	if err := rdbMaster.Do(ctx, "FT.DROPINDEX", index).Err(); err != nil {
+		return result, fmt.Errorf("dropping %s: %w", index, err)
+	}
+	return result, nil
+}
+
+// indexMemoryMB sums every memory figure FT.INFO reports for the index.
+func indexMemoryMB(info map[string]interface{}) float64 {
+	total := 0.0
+	for key := range info {
+		if strings.HasSuffix(key, "_sz_mb") || strings.HasSuffix(key, "_size_mb") {
+			total += infoFloat(info, key)
+		}
+	}
+	return total
+}
+
+func printExperimentReport(workloadName string, opsPerMinute int, results []experimentResult) {
+	fmt.Println("\n|===== Schema variant experiments =====|")
+	fmt.Printf("Workload %q offered at %d ops/min for %v per variant\n", workloadName, opsPerMinute, experimentStepDuration)
+	fmt.Printf("%-20s %-12s %-12s %-10s %-10s %-8s %-10s %-10s %-10s %s\n",
+		"Variant", "Seed/s", "Ops/min", "p50", "p99", "Errors", "Index MB", "Inverted", "Sortable", "Indexing wait")
+	for _, r := range results {
+		fmt.Printf("%-20s %-12.0f %-12d %-10v %-10v %-8s %-10.1f %-10.1f %-10.1f %v\n",
+			r.Variant, r.SeedPerSec, r.Load.AchievedOpsPerMinute,
+			r.Load.Latency.P50.Round(time.Microsecond), r.Load.Latency.P99.Round(time.Microsecond),
+			fmt.Sprintf("%.2f%%", r.Load.ErrorRatio()*100),
+			r.IndexMemMB, r.InvertedMB, r.SortableMB, r.IndexingTime.Round(time.Millisecond))
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SeedDrivers bulk-loads drivers 1..numDrivers into the master with pipelined
+// batches, reporting progress and returning the load throughput in drivers/s.
+// Statuses are reset to a generated fleet mix.
+func SeedDrivers() (float64, error) {
+	fmt.Printf("Seeding %d drivers...\n", numDrivers)
+
+	var (
+		seeded   atomic.Int64
+		firstErr error
+		errOnce  sync.Once
+		wg       sync.WaitGroup
+	)
+	ids := make(chan int64, seedGoroutinesCount)
+	startTime := time.Now()
+
+	// Report progress once per second until seeding finishes
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				count := seeded.Load()
+				fmt.Printf("  seeded %d/%d drivers (%.1f%%), %.0f drivers/s\n",
+					count, numDrivers, float64(count)*100/numDrivers, float64(count)/time.Since(startTime).Seconds())
+			}
+		}
+	}()
+
+	for range seedGoroutinesCount {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for first := range ids {
+				last := min(first+seedBatchSize-1, numDrivers)
+				drivers := make([]Driver, 0, last-first+1)
+				for id := first; id <= last; id++ {
+					drivers = append(drivers, GenerateSeedDriver(id))
+				}
+
+				if err := RegisterDrivers(drivers); err != nil {
+					log.Printf("Seeding: error writing drivers %d-%d: %v", first, last, err)
+					errOnce.Do(func() { firstErr = err })
+					continue
+				}
+				seeded.Add(int64(len(drivers)))
+			}
+		}()
+	}
+
+	for first := int64(1); first <= numDrivers; first += seedBatchSize {
+		ids <- first
+	}
+	close(ids)
+	wg.Wait()
+	close(done)
+
+	elapsed := time.Since(startTime)
+	throughput := float64(seeded.Load()) / elapsed.Seconds()
+	fmt.Printf("Seeded %d drivers in %v (%.0f drivers/s)\n", seeded.Load(), elapsed.Round(time.Millisecond), throughput)
+
+	return throughput, firstErr
+}
+
+// Warmup runs the configured workload mix for warmupDuration and discards the
+// results, so caches and connection pools are warm when measurement starts.
+func Warmup() {
+	if warmupDuration <= 0 {
+		return
+	}
+
+	fmt.Printf("Warming up for %v...\n", warmupDuration)
+	totalOpsPerMinute := 0
+	for _, w := range benchmarkWorkloads {
+		totalOpsPerMinute += w.Weight
+	}
+	result := runPacedLoad(benchmarkWorkloads, totalOpsPerMinute, writeGoroutinesCount+readGoroutinesCount*3, warmupDuration)
+	fmt.Printf("Warmup finished: %d operations, %d errors (discarded)\n", result.Operations, result.Errors)
+}
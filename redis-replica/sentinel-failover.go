@@ -0,0 +1,346 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	failoverKeyPrefix = "bench:failover:"
+	failoverMarkerKey = "bench:failover-marker"
+)
+
+// hostAddr translates an address announced by Sentinel to one reachable from
+// the benchmark, addresses without a mapping are used as they are.
+func hostAddr(addr string) string {
+	if mapped, ok := announcedAddrs[addr]; ok {
+		return mapped
+	}
+	return addr
+}
+
+func hostDialer(ctx context.Context, network, addr string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: time.Second * 5, KeepAlive: time.Minute * 5}
+	return dialer.DialContext(ctx, network, hostAddr(addr))
+}
+
+// newMasterClient returns a client for the master. With Sentinel it follows the
+// master through failovers, otherwise it is pinned to masterAddr.
+func newMasterClient() *redis.Client {
+	if useSentinel {
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:    sentinelMasterName,
+			SentinelAddrs: sentinelAddrs,
+			Dialer:        hostDialer,
+		})
+	}
+	return redis.NewClient(&redis.Options{
+		Addr: masterAddr,
+	})
+}
+
+// discoverTopology asks the sentinels, in order, for the current master and its
+// healthy replicas.
+func discoverTopology() (string, []string, error) {
+	var lastErr error
+	for _, addr := range sentinelAddrs {
+		sentinel := redis.NewSentinelClient(&redis.Options{
+			Addr: addr,
+		})
+		master, replicaList, err := querySentinel(sentinel)
+		sentinel.Close()
+		if err == nil {
+			return master, replicaList, nil
+		}
+		lastErr = fmt.Errorf("%s: %w", addr, err)
+	}
+	return "", nil, fmt.Errorf("no sentinel answered: %w", lastErr)
+}
+
+func querySentinel(sentinel *redis.SentinelClient) (string, []string, error) {
+	master, err := sentinel.GetMasterAddrByName(ctx, sentinelMasterName).Result()
+	if err != nil {
+		return "", nil, err
+	}
+	if len(master) != 2 {
+		return "", nil, fmt.Errorf("unexpected master address %v", master)
+	}
+
+	entries, err := sentinel.Replicas(ctx, sentinelMasterName).Result()
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Replicas that are down or still resyncing are left out of the read pool
+	replicaList := []string{}
+	for _, entry := range entries {
+		flags := entry["flags"]
+		if strings.Contains(flags, "s_down") || strings.Contains(flags, "o_down") || strings.Contains(flags, "disconnected") {
+			continue
+		}
+		if entry["master-link-status"] != "ok" {
+			continue
+		}
+		replicaList = append(replicaList, hostAddr(net.JoinHostPort(entry["ip"], entry["port"])))
+	}
+	sort.Strings(replicaList)
+
+	return hostAddr(net.JoinHostPort(master[0], master[1])), replicaList, nil
+}
+
+// waitForSentinel polls the sentinels until they report a master with at least
+// one replica or the deadline passes.
+func waitForSentinel(deadline time.Time) (string, []string, error) {
+	fmt.Printf("[Bootstrap] Asking sentinels for master '%s'", sentinelMasterName)
+	for {
+		master, replicaList, err := discoverTopology()
+		if err == nil && len(replicaList) > 0 {
+			fmt.Printf(" master %s, replicas %s\n", master, strings.Join(replicaList, ", "))
+			return master, replicaList, nil
+		}
+		if time.Now().After(deadline) {
+			fmt.Println()
+			if err == nil {
+				err = fmt.Errorf("master %s has no healthy replicas", master)
+			}
+			return "", nil, err
+		}
+		fmt.Print(".")
+		time.Sleep(time.Second)
+	}
+}
+
+// FailoverReport describes the impact of a Sentinel failover on the benchmark.
+type FailoverReport struct {
+	OldMaster        string
+	NewMaster        string
+	Promotion        time.Duration // failover issued until sentinels report the new master
+	WriteUnavailable time.Duration // longest gap between two acknowledged writes
+	MarkerWritten    time.Duration // failover issued until the new master accepted the marker write
+	ReadsRecovered   time.Duration // marker written until every replica of the new master serves fresh reads
+	AckedWrites      int
+	FailedWrites     int
+	LostWrites       int // acknowledged at any point but missing on the new master
+}
+
+// ackWriter writes sequenced keys to the master and remembers when each write
+// was acknowledged.
+type ackWriter struct {
+	stop   chan struct{}
+	done   chan struct{}
+	mu     sync.Mutex
+	acks   []int64
+	ackAt  []time.Time
+	failed int
+}
+
+func startAckWriter() *ackWriter {
+	w := &ackWriter{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+
+	go func() {
+		defer close(w.done)
+		ticker := time.NewTicker(failoverWriteInterval)
+		defer ticker.Stop()
+
+		var seq int64
+		for {
+			select {
+			case <-w.stop:
+				return
+			case <-ticker.C:
+				seq++
+				err := rdbMaster.Set(ctx, failoverKeyPrefix+strconv.FormatInt(seq, 10), seq, time.Hour).Err()
+
+				w.mu.Lock()
+				if err != nil {
+					w.failed++
+				} else {
+					w.acks = append(w.acks, seq)
+					w.ackAt = append(w.ackAt, time.Now())
+				}
+				w.mu.Unlock()
+			}
+		}
+	}()
+
+	return w
+}
+
+func (w *ackWriter) Stop() {
+	close(w.stop)
+	<-w.done
+}
+
+// longestGap returns the longest time between two consecutive acknowledged writes.
+func (w *ackWriter) longestGap() time.Duration {
+	longest := time.Duration(0)
+	for i := 1; i < len(w.ackAt); i++ {
+		if gap := w.ackAt[i].Sub(w.ackAt[i-1]); gap > longest {
+			longest = gap
+		}
+	}
+	return longest
+}
+
+// lostWrites counts the acknowledged writes the current master does not have.
+// Writes acknowledged by the old master after the failover was issued are the
+// most likely to be lost, so every sequence number is checked.
+func (w *ackWriter) lostWrites() (int, error) {
+	const batch = 1_000
+	lost := 0
+	for start := 0; start < len(w.acks); start += batch {
+		pipe := rdbMaster.Pipeline()
+		cmds := []*redis.IntCmd{}
+		for i := start; i < start+batch && i < len(w.acks); i++ {
+			cmds = append(cmds, pipe.Exists(ctx, failoverKeyPrefix+strconv.FormatInt(w.acks[i], 10)))
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return lost, err
+		}
+		for _, cmd := range cmds {
+			if cmd.Val() == 0 {
+				lost++
+			}
+		}
+	}
+	return lost, nil
+}
+
+// MeasureFailover keeps a sequenced writer running against the master, asks
+// Sentinel to fail over and tracks how long writes and reads are affected.
+// The replica pool is switched to the replicas of the new master.
+func MeasureFailover() (FailoverReport, error) {
+	writer := startAckWriter()
+	defer func() {
+		select {
+		case <-writer.done:
+		default:
+			writer.Stop()
+		}
+	}()
+
+	time.Sleep(failoverStartDelay)
+
+	oldMaster, _, err := discoverTopology()
+	if err != nil {
+		return FailoverReport{}, err
+	}
+	report := FailoverReport{OldMaster: oldMaster}
+
+	fmt.Printf("[Failover] Asking sentinels to fail over %s (%s)...\n", sentinelMasterName, oldMaster)
+	issued := time.Now()
+	if err := failover(); err != nil {
+		return report, err
+	}
+
+	for {
+		master, _, err := discoverTopology()
+		if err == nil && master != oldMaster {
+			report.NewMaster = master
+			report.Promotion = time.Since(issued)
+			break
+		}
+		if time.Since(issued) > failoverTimeout {
+			return report, fmt.Errorf("no new master after %v", failoverTimeout)
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	fmt.Printf("[Failover] %s promoted after %v\n", report.NewMaster, report.Promotion.Round(time.Millisecond))
+
+	// Reads have recovered once the pool holds the new master's replicas and
+	// they return a value written after the promotion. The write client may
+	// still be on the demoted master, READONLY, so the marker is retried.
+	marker := strconv.FormatInt(time.Now().UnixNano(), 10)
+	for {
+		err := rdbMaster.Set(ctx, failoverMarkerKey, marker, 0).Err()
+		if err == nil {
+			report.MarkerWritten = time.Since(issued)
+			break
+		}
+		if time.Since(issued) > failoverTimeout {
+			return report, fmt.Errorf("writing marker to new master: %w", err)
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	written := time.Now()
+	for {
+		_, replicaList, err := discoverTopology()
+		if err == nil && len(replicaList) > 0 {
+			if added, removed, err := replicas.Update(replicaList); err == nil {
+				if len(added) > 0 || len(removed) > 0 {
+					fmt.Printf("[Failover] Replica pool: +%v -%v\n", added, removed)
+				}
+				if markerVisible(marker) {
+					report.ReadsRecovered = time.Since(written)
+					break
+				}
+			}
+		}
+		if time.Since(issued) > failoverTimeout {
+			return report, fmt.Errorf("replicas did not serve the new master's writes after %v", failoverTimeout)
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	fmt.Printf("[Failover] Reads recovered after %v\n", report.ReadsRecovered.Round(time.Millisecond))
+
+	// Keep writing for a moment so the gap around the failover is closed
+	time.Sleep(time.Second * 5)
+	writer.Stop()
+
+	report.AckedWrites = len(writer.acks)
+	report.FailedWrites = writer.failed
+	report.WriteUnavailable = writer.longestGap()
+	report.LostWrites, err = writer.lostWrites()
+	if err != nil {
+		return report, fmt.Errorf("counting lost writes: %w", err)
+	}
+
+	return report, nil
+}
+
+func failover() error {
+	var lastErr error
+	for _, addr := range sentinelAddrs {
+		sentinel := redis.NewSentinelClient(&redis.Options{
+			Addr: addr,
+		})
+		err := sentinel.Failover(ctx, sentinelMasterName).Err()
+		sentinel.Close()
+		if err == nil {
+			return nil
+		}
+		lastErr = fmt.Errorf("%s: %w", addr, err)
+	}
+	return lastErr
+}
+
+func markerVisible(marker string) bool {
+	for _, client := range replicas.Clients() {
+		if got, err := client.Get(ctx, failoverMarkerKey).Result(); err != nil || got != marker {
+			return false
+		}
+	}
+	return true
+}
+
+func printFailoverReport(report FailoverReport) {
+	fmt.Println("\n|===== Failover =====|")
+	fmt.Printf("Master: %s -> %s\n", report.OldMaster, report.NewMaster)
+	fmt.Printf("Promotion: %v\n", report.Promotion.Round(time.Millisecond))
+	fmt.Printf("Write unavailability: %v\n", report.WriteUnavailable.Round(time.Millisecond))
+	fmt.Printf("New master accepted writes: %v\n", report.MarkerWritten.Round(time.Millisecond))
+	fmt.Printf("Reads recovered on new topology: %v\n", report.ReadsRecovered.Round(time.Millisecond))
+	fmt.Printf("Tracked writes: %d acknowledged, %d failed, %d acknowledged but lost\n",
+		report.AckedWrites, report.FailedWrites, report.LostWrites)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// SLOThreshold declares the limits a workload must hold for the run to pass.
+// Zero values are not checked.
+type SLOThreshold struct {
+	MinOpsPerMinute   int
+	MaxP99            time.Duration
+	MaxErrorRatio     float64
+	MaxReplicationLag time.Duration
+}
+
+type sloCheck struct {
+	Workload  string
+	Check     string
+	Threshold string
+	Actual    string
+	Passed    bool
+}
+
+// evaluateSLOs checks every declared threshold against the run results, prints a
+// pass/fail table and reports whether all of them held.
+func evaluateSLOs(thresholds map[string]SLOThreshold, results map[string]LoadResult, replicationLag LatencySummary) bool {
+	checks := []sloCheck{}
+
+	for _, w := range benchmarkWorkloads {
+		threshold, ok := thresholds[w.Name]
+		if !ok {
+			continue
+		}
+		result, ok := results[w.Name]
+		if !ok {
+			checks = append(checks, sloCheck{Workload: w.Name, Check: "result", Threshold: "present", Actual: "missing"})
+			continue
+		}
+
+		if threshold.MinOpsPerMinute > 0 {
+			checks = append(checks, sloCheck{
+				Workload:  w.Name,
+				Check:     "ops/min",
+				Threshold: fmt.Sprintf(">= %d", threshold.MinOpsPerMinute),
+				Actual:    fmt.Sprintf("%d", result.AchievedOpsPerMinute),
+				Passed:    result.AchievedOpsPerMinute >= threshold.MinOpsPerMinute,
+			})
+		}
+		if threshold.MaxP99 > 0 {
+			checks = append(checks, sloCheck{
+				Workload:  w.Name,
+				Check:     "p99 latency",
+				Threshold: fmt.Sprintf("<= %v", threshold.MaxP99),
+				Actual:    result.Latency.P99.Round(time.Microsecond).String(),
+				Passed:    result.Latency.P99 <= threshold.MaxP99,
+			})
+		}
+		if threshold.MaxErrorRatio > 0 {
+			checks = append(checks, sloCheck{
+				Workload:  w.Name,
+				Check:     "error ratio",
+				Threshold: fmt.Sprintf("<= %.3f%%", threshold.MaxErrorRatio*100),
+				Actual:    fmt.Sprintf("%.3f%%", result.ErrorRatio()*100),
+				Passed:    result.ErrorRatio() <= threshold.MaxErrorRatio,
+			})
+		}
+		if threshold.MaxReplicationLag > 0 {
+			checks = append(checks, sloCheck{
+				Workload:  w.Name,
+				Check:     "replication lag (max)",
+				Threshold: fmt.Sprintf("<= %v", threshold.MaxReplicationLag),
+				Actual:    replicationLag.Max.Round(time.Microsecond).String(),
+				Passed:    replicationLag.Max <= threshold.MaxReplicationLag,
+			})
+		}
+	}
+
+	fmt.Println("\n|===== SLO assertions =====|")
+	fmt.Printf("%-12s %-22s %-14s %-14s %s\n", "Workload", "Check", "Threshold", "Actual", "Result")
+	allPassed := true
+	for _, c := range checks {
+		status := "PASS"
+		if !c.Passed {
+			status = "FAIL"
+			allPassed = false
+		}
+		fmt.Printf("%-12s %-22s %-14s %-14s %s\n", c.Workload, c.Check, c.Threshold, c.Actual, status)
+	}
+
+	return allPassed
+}
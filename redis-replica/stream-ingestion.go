@@ -0,0 +1,394 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	ingestStreamKey = "driver_pings"
+	ingestGroup     = "ingesters"
+)
+
+// ingestResult is what one ingestion mode achieved. Searchable is the time from
+// sending a sampled ping until a search on a replica finds it.
+type ingestResult struct {
+	Mode          string
+	Sent          int64
+	Applied       int64 // pings that passed the ordering check
+	Errors        int64
+	Reclaimed     int64 // pending entries taken over from a stuck consumer
+	Dropped       int64 // batches a consumer gave up on without XACK
+	Backlog       int64 // entries not applied when the run ended
+	Duration      time.Duration
+	Searchable    LatencySummary
+	NotSearchable int64
+}
+
+// pingPublisher hands a batch of pings to the write path under test.
+type pingPublisher func(pings []LocationUpdate) error
+
+// runIngestBenchmark sends the same ping load once straight to UpdateDriverLocations
+// and once through a stream read by a consumer group, and compares throughput
+// and ping-to-searchable latency.
+func runIngestBenchmark() error {
+	var directApplied atomic.Int64
+	direct := measureIngest("direct", func(pings []LocationUpdate) error {
+		applied, err := UpdateDriverLocations(pings)
+		for _, ok := range applied {
+			if ok {
+				directApplied.Add(1)
+			}
+		}
+		return err
+	}, nil)
+	direct.Applied = directApplied.Load()
+
+	ingester, err := startStreamIngester()
+	if err != nil {
+		return err
+	}
+	streamed := measureIngest("stream", publishPings, ingester)
+
+	printIngestReport([]ingestResult{direct, streamed})
+	return nil
+}
+
+// measureIngest runs ingestProducersCount simulated driver apps sending location
+// pings in batches of ingestBatchSize at ingestPingsPerMinute for ingestDuration.
+// With an ingester it waits until the stream is drained before stopping it.
+func measureIngest(mode string, publish pingPublisher, ingester *streamIngester) ingestResult {
+	result := ingestResult{Mode: mode}
+	probes := &searchProbes{}
+
+	fmt.Printf("[Ingest] %s: %d producers sending %d pings per minute for %v...\n",
+		mode, ingestProducersCount, ingestPingsPerMinute, ingestDuration)
+	start := time.Now()
+	deadline := start.Add(ingestDuration)
+	interval := time.Minute * ingestBatchSize * ingestProducersCount / ingestPingsPerMinute
+
+	var wg sync.WaitGroup
+	for range ingestProducersCount {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+
+			for time.Now().Before(deadline) {
+				pings := make([]LocationUpdate, ingestBatchSize)
+				for i := range pings {
+					d := GenerateFakeDriver(getNextDriverId())
+					pings[i] = LocationUpdate{Id: d.Id, Location: d.Location, GeoHash: d.GeoHash, LastUpdatedTime: d.LastUpdatedTime}
+				}
+
+				sent := time.Now()
+				if err := publish(pings); err != nil {
+					atomic.AddInt64(&result.Errors, 1)
+					log.Printf("[Ingest] %s: error sending pings: %v", mode, err)
+				} else {
+					atomic.AddInt64(&result.Sent, int64(len(pings)))
+					for _, p := range pings {
+						if rand.Float64() < ingestProbeRatio {
+							probes.follow(p, sent)
+						}
+					}
+				}
+				<-ticker.C
+			}
+		}()
+	}
+	wg.Wait()
+
+	if ingester != nil {
+		result.Backlog = ingester.drain(ingestDrainTimeout)
+		stats := ingester.stop()
+		result.Applied = stats.Applied
+		result.Errors += stats.Errors
+		result.Reclaimed = stats.Reclaimed
+		result.Dropped = stats.Dropped
+	}
+	result.Duration = time.Since(start)
+	result.Searchable, result.NotSearchable = probes.wait()
+	return result
+}
+
+// publishPings appends the pings to the stream in one round trip. The stream is
+// capped at about ingestStreamMaxLen entries.
+func publishPings(pings []LocationUpdate) error {
+	pipe := rdbMaster.Pipeline()
+	for _, p := range pings {
+		pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: ingestStreamKey,
+			MaxLen: ingestStreamMaxLen,
+			Approx: true,
+			Values: []interface{}{
+				"id", p.Id,
+				"lat", p.Location.Lat,
+				"long", p.Location.Long,
+				"geo_hash", p.GeoHash,
+				"last_updated_time", p.LastUpdatedTime,
+			},
+		})
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func parsePing(values map[string]interface{}) (LocationUpdate, error) {
+	field := func(name string) string {
+		s, _ := values[name].(string)
+		return s
+	}
+
+	var (
+		p   LocationUpdate
+		err error
+	)
+	if p.Id, err = strconv.ParseInt(field("id"), 10, 64); err != nil {
+		return p, fmt.Errorf("invalid id: %w", err)
+	}
+	if p.Location.Lat, err = strconv.ParseFloat(field("lat"), 64); err != nil {
+		return p, fmt.Errorf("invalid lat: %w", err)
+	}
+	if p.Location.Long, err = strconv.ParseFloat(field("long"), 64); err != nil {
+		return p, fmt.Errorf("invalid long: %w", err)
+	}
+	p.GeoHash = field("geo_hash")
+	p.LastUpdatedTime = field("last_updated_time")
+	return p, nil
+}
+
+type ingesterStats struct {
+	Applied   int64
+	Errors    int64
+	Reclaimed int64
+	Dropped   int64
+}
+
+// streamIngester runs ingestConsumersCount consumers of the ingestGroup group.
+// A batch is acknowledged only after it was written, so a batch of a consumer
+// that failed stays pending and is reclaimed by another one after
+// ingestClaimIdle.
+type streamIngester struct {
+	stats  ingesterStats
+	cancel context.CancelFunc
+	done   sync.WaitGroup
+}
+
+// startStreamIngester recreates the stream and its group and starts the consumers.
+func startStreamIngester() (*streamIngester, error) {
+	if err := rdbMaster.Del(ctx, ingestStreamKey).Err(); err != nil {
+		return nil, err
+	}
+	if err := rdbMaster.XGroupCreateMkStream(ctx, ingestStreamKey, ingestGroup, "$").Err(); err != nil {
+		return nil, fmt.Errorf("creating consumer group: %w", err)
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	in := &streamIngester{cancel: cancel}
+	for i := range ingestConsumersCount {
+		in.done.Add(1)
+		go in.consume(runCtx, fmt.Sprintf("ingester-%d", i))
+	}
+	return in, nil
+}
+
+func (in *streamIngester) consume(runCtx context.Context, consumer string) {
+	defer in.done.Done()
+	lastClaim := time.Now()
+
+	for runCtx.Err() == nil {
+		if time.Since(lastClaim) >= ingestClaimIdle {
+			in.reclaim(consumer)
+			lastClaim = time.Now()
+		}
+
+		streams, err := rdbMaster.XReadGroup(runCtx, &redis.XReadGroupArgs{
+			Group:    ingestGroup,
+			Consumer: consumer,
+			Streams:  []string{ingestStreamKey, ">"},
+			Count:    ingestBatchSize,
+			Block:    time.Millisecond * 100,
+		}).Result()
+		if errors.Is(err, redis.Nil) || runCtx.Err() != nil {
+			continue
+		}
+		if err != nil {
+			atomic.AddInt64(&in.stats.Errors, 1)
+			log.Printf("[Ingest] %s: error reading stream: %v", consumer, err)
+			time.Sleep(time.Millisecond * 100)
+			continue
+		}
+		for _, s := range streams {
+			in.apply(consumer, s.Messages)
+		}
+	}
+}
+
+// reclaim takes over entries other consumers read but did not acknowledge
+// within ingestClaimIdle and applies them.
+func (in *streamIngester) reclaim(consumer string) {
+	start := "0-0"
+	for {
+		messages, next, err := rdbMaster.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   ingestStreamKey,
+			Group:    ingestGroup,
+			Consumer: consumer,
+			MinIdle:  ingestClaimIdle,
+			Start:    start,
+			Count:    ingestBatchSize,
+		}).Result()
+		if err != nil {
+			atomic.AddInt64(&in.stats.Errors, 1)
+			log.Printf("[Ingest] %s: error reclaiming pending entries: %v", consumer, err)
+			return
+		}
+		if len(messages) > 0 {
+			atomic.AddInt64(&in.stats.Reclaimed, int64(len(messages)))
+			in.apply(consumer, messages)
+		}
+		if next == "0-0" || len(messages) == 0 {
+			return
+		}
+		start = next
+	}
+}
+
+// apply writes a batch and acknowledges it. At ingestDropRatio the consumer acts
+// as if it crashed mid-batch and leaves the entries pending.
+func (in *streamIngester) apply(consumer string, messages []redis.XMessage) {
+	if rand.Float64() < ingestDropRatio {
+		atomic.AddInt64(&in.stats.Dropped, 1)
+		return
+	}
+
+	pings := make([]LocationUpdate, 0, len(messages))
+	ids := make([]string, 0, len(messages))
+	for _, m := range messages {
+		ids = append(ids, m.ID)
+		p, err := parsePing(m.Values)
+		if err != nil {
+			// Retrying cannot fix a malformed entry, acknowledge it with the batch
+			atomic.AddInt64(&in.stats.Errors, 1)
+			log.Printf("[Ingest] %s: skipping entry %s: %v", consumer, m.ID, err)
+			continue
+		}
+		pings = append(pings, p)
+	}
+
+	applied, err := UpdateDriverLocations(pings)
+	if err != nil {
+		atomic.AddInt64(&in.stats.Errors, 1)
+		log.Printf("[Ingest] %s: error applying pings: %v", consumer, err)
+		return
+	}
+	for _, ok := range applied {
+		if ok {
+			atomic.AddInt64(&in.stats.Applied, 1)
+		}
+	}
+
+	if err := rdbMaster.XAck(ctx, ingestStreamKey, ingestGroup, ids...).Err(); err != nil {
+		atomic.AddInt64(&in.stats.Errors, 1)
+		log.Printf("[Ingest] %s: error acknowledging entries: %v", consumer, err)
+	}
+}
+
+// drain waits until the group read and acknowledged every entry and returns the
+// entries still unread or pending at the timeout.
+func (in *streamIngester) drain(timeout time.Duration) int64 {
+	deadline := time.Now().Add(timeout)
+	for {
+		var backlog int64 = -1
+		groups, err := rdbMaster.XInfoGroups(ctx, ingestStreamKey).Result()
+		for _, g := range groups {
+			if g.Name == ingestGroup && g.Lag >= 0 {
+				backlog = g.Lag + g.Pending
+			}
+		}
+		if err == nil && backlog == 0 {
+			return 0
+		}
+		if time.Now().After(deadline) {
+			log.Printf("[Ingest] Stream not drained within %v, backlog %d", timeout, backlog)
+			return backlog
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+}
+
+// stop ends the consumers and removes the stream.
+func (in *streamIngester) stop() ingesterStats {
+	in.cancel()
+	in.done.Wait()
+	if err := rdbMaster.Del(ctx, ingestStreamKey).Err(); err != nil {
+		log.Printf("[Ingest] Error deleting stream: %v", err)
+	}
+	return in.stats
+}
+
+// searchProbes follows sampled pings until a search on a replica finds them.
+type searchProbes struct {
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+	samples  []time.Duration
+	timeouts int64
+}
+
+func (p *searchProbes) follow(ping LocationUpdate, sent time.Time) {
+	// The geo hash of a ping is practically unique, the old state of the driver
+	// does not match it
+	geoHash := TextPrefix("geo_hash", ping.GeoHash)
+	if activeFieldType("geo_hash") == "TAG" {
+		geoHash = TagPrefix("geo_hash", ping.GeoHash)
+	}
+	query := And(NumericRange("driver_id", float64(ping.Id), float64(ping.Id)), geoHash)
+
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		for time.Since(sent) < ingestProbeTimeout {
+			reply, err := replicas.Get().Do(ctx, "FT.SEARCH", searchIndex(), query.String(), "LIMIT", 0, 0).Slice()
+			if err == nil && len(reply) > 0 {
+				if total, ok := reply[0].(int64); ok && total > 0 {
+					p.mu.Lock()
+					p.samples = append(p.samples, time.Since(sent))
+					p.mu.Unlock()
+					return
+				}
+			}
+			time.Sleep(time.Millisecond)
+		}
+		atomic.AddInt64(&p.timeouts, 1)
+	}()
+}
+
+func (p *searchProbes) wait() (LatencySummary, int64) {
+	p.wg.Wait()
+	return summarizeLatencies(p.samples), p.timeouts
+}
+
+func printIngestReport(results []ingestResult) {
+	fmt.Println("\n|===== Ingestion =====|")
+	for _, r := range results {
+		perMinute := float64(r.Sent) / r.Duration.Minutes()
+		fmt.Printf("%-7s sent %d pings in %v (%.0f per minute including drain), applied %d, errors %d\n",
+			r.Mode, r.Sent, r.Duration.Round(time.Millisecond), perMinute, r.Applied, r.Errors)
+		if r.Mode == "stream" {
+			fmt.Printf("        dropped batches %d, reclaimed entries %d, backlog %d\n", r.Dropped, r.Reclaimed, r.Backlog)
+		}
+		fmt.Printf("        ping-to-searchable p50 %v, p99 %v, max %v (%d samples, %d not searchable within %v)\n",
+			r.Searchable.P50.Round(time.Microsecond), r.Searchable.P99.Round(time.Microsecond), r.Searchable.Max.Round(time.Microsecond),
+			r.Searchable.Count, r.NotSearchable, ingestProbeTimeout)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync/atomic"
+)
+
+// Kinds of updates in a benchmark write batch.
+const (
+	updateFresh     = iota
+	updateStale     // an older ping delivered after a newer one
+	updateDuplicate // the same ping delivered twice
+)
+
+// UpdateOrderingStats counts how the conditional upserts treated each kind of
+// update. Stale and duplicate updates must all be rejected, fresh ones applied.
+type UpdateOrderingStats struct {
+	Fresh             atomic.Int64
+	FreshRejected     atomic.Int64
+	Stale             atomic.Int64
+	StaleRejected     atomic.Int64
+	Duplicate         atomic.Int64
+	DuplicateRejected atomic.Int64
+}
+
+var updateOrdering atomic.Pointer[UpdateOrderingStats]
+
+func init() {
+	resetUpdateOrdering()
+}
+
+func resetUpdateOrdering() {
+	updateOrdering.Store(&UpdateOrderingStats{})
+}
+
+// buildWriteBatch generates size fresh pings and, at staleUpdateRatio and
+// duplicateUpdateRatio, appends delayed and repeated copies of some of them, so
+// they arrive after the ping they are older than or equal to.
+func buildWriteBatch(size int) ([]Driver, []int) {
+	drivers := make([]Driver, 0, size)
+	kinds := make([]int, 0, size)
+	for i := 0; i < size; i++ {
+		drivers = append(drivers, GenerateFakeDriver(getNextDriverId()))
+		kinds = append(kinds, updateFresh)
+	}
+
+	for i := 0; i < size; i++ {
+		if rand.Float64() < staleUpdateRatio {
+			stale := drivers[i]
+			lastUpdated, _ := strconv.ParseInt(stale.LastUpdatedTime, 10, 64)
+			delay := rand.Int63n(maxUpdateDelay.Milliseconds()) + 1
+			stale.LastUpdatedTime = strconv.FormatInt(lastUpdated-delay, 10)
+			drivers = append(drivers, stale)
+			kinds = append(kinds, updateStale)
+		}
+		if rand.Float64() < duplicateUpdateRatio {
+			drivers = append(drivers, drivers[i])
+			kinds = append(kinds, updateDuplicate)
+		}
+	}
+	return drivers, kinds
+}
+
+// writeBatch upserts the batch and records which updates were rejected.
+func writeBatch(drivers []Driver, kinds []int) error {
+	applied, err := UpsertDriversApplied(drivers)
+	if err != nil {
+		return err
+	}
+	recordUpdateOrdering(applied, kinds)
+	return nil
+}
+
+// writeLocationBatch sends only the location part of the batch.
+func writeLocationBatch(drivers []Driver, kinds []int) error {
+	updates := make([]LocationUpdate, len(drivers))
+	for i, d := range drivers {
+		updates[i] = LocationUpdate{Id: d.Id, Location: d.Location, GeoHash: d.GeoHash, LastUpdatedTime: d.LastUpdatedTime}
+	}
+
+	applied, err := UpdateDriverLocations(updates)
+	if err != nil {
+		return err
+	}
+	recordUpdateOrdering(applied, kinds)
+	return nil
+}
+
+func recordUpdateOrdering(applied []bool, kinds []int) {
+	stats := updateOrdering.Load()
+	for i, kind := range kinds {
+		switch kind {
+		case updateFresh:
+			stats.Fresh.Add(1)
+			if !applied[i] {
+				stats.FreshRejected.Add(1)
+			}
+		case updateStale:
+			stats.Stale.Add(1)
+			if !applied[i] {
+				stats.StaleRejected.Add(1)
+			}
+		case updateDuplicate:
+			stats.Duplicate.Add(1)
+			if !applied[i] {
+				stats.DuplicateRejected.Add(1)
+			}
+		}
+	}
+}
+
+func printUpdateOrdering() {
+	stats := updateOrdering.Load()
+	fmt.Println("\n|===== Update ordering =====|")
+	fmt.Printf("Stale updates (up to %v late): %d injected, %d rejected\n", maxUpdateDelay, stats.Stale.Load(), stats.StaleRejected.Load())
+	fmt.Printf("Duplicate updates: %d injected, %d rejected\n", stats.Duplicate.Load(), stats.DuplicateRejected.Load())
+	fmt.Printf("Fresh updates: %d sent, %d rejected\n", stats.Fresh.Load(), stats.FreshRejected.Load())
+
+	wronglyApplied := stats.Stale.Load() - stats.StaleRejected.Load() + stats.Duplicate.Load() - stats.DuplicateRejected.Load()
+	if wronglyApplied > 0 || stats.FreshRejected.Load() > 0 {
+		fmt.Printf("WARNING: %d out-of-order updates applied, %d fresh updates rejected\n", wronglyApplied, stats.FreshRejected.Load())
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Workload is a single benchmark operation that can be driven by the paced load generator.
+type Workload struct {
+	Name       string
+	OpsPerCall int // how many operations one call accounts for (write batches count every driver)
+	Weight     int // share of operations when the workload is part of the mix
+	Call       func() error
+	// Lookup replaces Call for batch lookups, so missing and malformed ids are
+	// counted per driver instead of failing or passing the whole call
+	Lookup func() (DriverLookup, error)
+}
+
+// callCounts splits the drivers of one call by outcome, in the same unit as OpsPerCall.
+type callCounts struct {
+	Operations int
+	Errors     int
+	NotFound   int
+	Malformed  int
+	LostRaces  int
+}
+
+// run calls the workload once. A lenient batch lookup counts its usable
+// drivers as operations, even those that also count as malformed.
+func (w Workload) run() callCounts {
+	if w.Lookup != nil {
+		lookup, err := w.Lookup()
+		switch {
+		case err == nil, errors.Is(err, ErrMalformedDriver) && parseMode == parseLenient:
+			return callCounts{Operations: len(lookup.Found), NotFound: len(lookup.Missing), Malformed: len(lookup.Malformed)}
+		case errors.Is(err, ErrMalformedDriver):
+			return callCounts{Malformed: w.OpsPerCall}
+		default:
+			return callCounts{Errors: w.OpsPerCall}
+		}
+	}
+
+	err := w.Call()
+	switch {
+	case err == nil:
+		return callCounts{Operations: w.OpsPerCall}
+	case errors.Is(err, ErrDriverNotFound):
+		return callCounts{NotFound: w.OpsPerCall}
+	case errors.Is(err, ErrMalformedDriver):
+		return callCounts{Malformed: w.OpsPerCall}
+	case errors.Is(err, ErrStatusChanged):
+		return callCounts{LostRaces: w.OpsPerCall}
+	default:
+		return callCounts{Errors: w.OpsPerCall}
+	}
+}
+
+var benchmarkWorkloads = []Workload{
+	{
+		Name:       "write",
+		OpsPerCall: writeBatchSize,
+		Weight:     writeOpsPerMinute,
+		Call: func() error {
+			return planWriteBatch(writeBatchSize).run()
+		},
+	},
+	{
+		Name:       "single-get",
+		OpsPerCall: 1,
+		Weight:     singleGetOpsPerMinute,
+		Call: func() error {
+			_, err := GetDriver(getNextDriverIdRead())
+			return err
+		},
+	},
+	{
+		Name:       "batch-get",
+		OpsPerCall: batchGetSize,
+		Weight:     batchGetOpsPerMinute,
+		Lookup: func() (DriverLookup, error) {
+			ids := make([]int64, batchGetSize)
+			for i := range ids {
+				ids[i] = rand.Int63n(numDrivers) + 1
+			}
+			return GetDrivers(ids)
+		},
+	},
+	{
+		Name:       "radius",
+		OpsPerCall: 1,
+		Weight:     multiGetRadOpsPerMinute,
+		Call: func() error {
+			lat, lng, _ := GetRandomLatLong()
+			_, err := GetDriverInRadius(Location{Lat: lat, Long: lng}, 5, 20, DriverFilter{})
+			return err
+		},
+	},
+	{
+		Name:       "geohash",
+		OpsPerCall: 1,
+		Weight:     multiGetGeoHashOpsPerMinute,
+		Call: func() error {
+			_, _, geohash := GetRandomLatLong()
+			_, err := GetDriverForOrder(geohash, GetRandomTariffs(), 5, DriverFilter{})
+			return err
+		},
+	},
+	{
+		Name:       "status",
+		OpsPerCall: 1,
+		Weight:     statusOpsPerMinute,
+		Call: func() error {
+			return advanceDriverStatus(getNextDriverIdRead())
+		},
+	},
+}
+
+// filteredWorkloads are the dispatch queries with the battery and freshness
+// filters of schema v2. They are not part of the mix, select them by name or all
+// of them with "filtered", e.g. `go run . saturate filtered`.
+var filteredWorkloads = []Workload{
+	{
+		Name:       "radius-filtered",
+		OpsPerCall: 1,
+		Weight:     multiGetRadOpsPerMinute,
+		Call: func() error {
+			lat, lng, _ := GetRandomLatLong()
+			_, err := GetDriverInRadius(Location{Lat: lat, Long: lng}, 5, 20, dispatchFilter)
+			return err
+		},
+	},
+	{
+		Name:       "geohash-filtered",
+		OpsPerCall: 1,
+		Weight:     multiGetGeoHashOpsPerMinute,
+		Call: func() error {
+			_, _, geohash := GetRandomLatLong()
+			_, err := GetDriverForOrder(geohash, GetRandomTariffs(), 5, dispatchFilter)
+			return err
+		},
+	},
+}
+
+var dispatchFilter = DriverFilter{MinCharge: dispatchMinCharge, MaxAge: dispatchMaxAge}
+
+// callWeight converts the operation share into a share of calls, so a write
+// batch is picked OpsPerCall times less often than a single read.
+func (w Workload) callWeight() int {
+	return max(w.Weight/w.OpsPerCall, 1)
+}
+
+// findWorkloads returns the named workload, all benchmark workloads for "mix"
+// or all filtered ones for "filtered".
+func findWorkloads(name string) ([]Workload, error) {
+	switch name {
+	case "mix":
+		return benchmarkWorkloads, nil
+	case "filtered":
+		return filteredWorkloads, nil
+	}
+	for _, w := range append(benchmarkWorkloads, filteredWorkloads...) {
+		if w.Name == name {
+			return []Workload{w}, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown workload %q", name)
+}
+
+// LoadResult is the outcome of running workloads at a fixed offered rate.
+type LoadResult struct {
+	OfferedOpsPerMinute  int
+	AchievedOpsPerMinute int
+	Operations           int
+	Errors               int // operations in failed calls, in the same unit as Operations
+	NotFound             int // drivers that were not found, not counted as errors
+	Malformed            int // drivers with malformed data, not counted as errors
+	LostRaces            int // status transitions another writer got to first, not counted as errors
+	Latency              LatencySummary
+}
+
+func (r LoadResult) ErrorRatio() float64 {
+	total := r.Operations + r.Errors
+	if total == 0 {
+		return 0
+	}
+	return float64(r.Errors) / float64(total)
+}
+
+// runPacedLoad offers opsPerMinute operations spread over the given number of
+// goroutines for the given duration. Each worker follows its own schedule, so
+// when Redis cannot keep up the achieved rate falls behind the offered one.
+func runPacedLoad(workloads []Workload, opsPerMinute, goroutines int, duration time.Duration) LoadResult {
+	totalWeight := 0
+	for _, w := range workloads {
+		totalWeight += w.callWeight()
+	}
+
+	// Time budget of a single operation for one worker
+	interval := time.Duration(float64(time.Minute) * float64(goroutines) / float64(opsPerMinute))
+
+	var wg sync.WaitGroup
+	statsChan := make(chan Stats, goroutines)
+
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func(workerID int) {
+			defer wg.Done()
+
+			startTime := time.Now()
+			next := startTime
+			operationCount := 0
+			errorCount := 0
+			notFoundCount := 0
+			malformedCount := 0
+			lostRaceCount := 0
+			latencies := []time.Duration{}
+
+			for time.Since(startTime) < duration {
+				if wait := time.Until(next); wait > 0 {
+					time.Sleep(wait)
+				}
+
+				w := pickWorkload(workloads, totalWeight)
+				callStart := time.Now()
+				counts := w.run()
+				latencies = append(latencies, time.Since(callStart))
+				operationCount += counts.Operations
+				errorCount += counts.Errors
+				notFoundCount += counts.NotFound
+				malformedCount += counts.Malformed
+				lostRaceCount += counts.LostRaces
+
+				next = next.Add(interval * time.Duration(w.OpsPerCall))
+			}
+
+			statsChan <- Stats{
+				WorkerID:   workerID,
+				Operations: operationCount,
+				Errors:     errorCount,
+				NotFound:   notFoundCount,
+				Malformed:  malformedCount,
+				LostRaces:  lostRaceCount,
+				Duration:   time.Since(startTime),
+				Latencies:  latencies,
+			}
+		}(i)
+	}
+
+	wg.Wait()
+	close(statsChan)
+
+	result := LoadResult{OfferedOpsPerMinute: opsPerMinute}
+	latencies := []time.Duration{}
+	for stats := range statsChan {
+		result.Operations += stats.Operations
+		result.Errors += stats.Errors
+		result.NotFound += stats.NotFound
+		result.Malformed += stats.Malformed
+		result.LostRaces += stats.LostRaces
+		latencies = append(latencies, stats.Latencies...)
+	}
+	result.AchievedOpsPerMinute = int(float64(result.Operations) / duration.Minutes())
+	result.Latency = summarizeLatencies(latencies)
+
+	return result
+}
+
+func pickWorkload(workloads []Workload, totalWeight int) Workload {
+	if len(workloads) == 1 {
+		return workloads[0]
+	}
+	n := rand.Intn(totalWeight)
+	for _, w := range workloads {
+		if n < w.callWeight() {
+			return w
+		}
+		n -= w.callWeight()
+	}
+	return workloads[len(workloads)-1]
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// CoalescingWriter accepts driver updates from many producers and keeps only
+// the latest update per driver. Every window it flushes what it holds to
+// rdbMaster in pipelines of coalesceFlushBatch drivers, so an update superseded
+// within the window never costs a round trip or an index update.
+type CoalescingWriter struct {
+	window time.Duration
+
+	mu         sync.Mutex
+	pending    map[int64]coalescedUpdate
+	submitted  int64
+	superseded int64
+
+	statsMu        sync.Mutex
+	written        int64
+	errors         int64
+	flushLatencies []time.Duration
+	freshness      []time.Duration
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+type coalescedUpdate struct {
+	driver   Driver
+	received time.Time
+}
+
+// CoalescingReport is the outcome of a CoalescingWriter run. Freshness is the
+// time from submitting an update until it was written, the price of batching.
+type CoalescingReport struct {
+	Window       time.Duration // zero for the direct baseline
+	Submitted    int64
+	Written      int64
+	Superseded   int64
+	Errors       int64
+	Flushes      int
+	FlushLatency LatencySummary
+	Freshness    LatencySummary
+}
+
+// CoalescingRatio is the share of submitted updates that were never written
+// because a newer one replaced them.
+func (r CoalescingReport) CoalescingRatio() float64 {
+	if r.Submitted == 0 {
+		return 0
+	}
+	return float64(r.Superseded) / float64(r.Submitted)
+}
+
+func NewCoalescingWriter(window time.Duration) *CoalescingWriter {
+	w := &CoalescingWriter{
+		window:  window,
+		pending: map[int64]coalescedUpdate{},
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	go func() {
+		defer close(w.done)
+		ticker := time.NewTicker(window)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-w.stop:
+				w.flush()
+				return
+			case <-ticker.C:
+				w.flush()
+			}
+		}
+	}()
+
+	return w
+}
+
+// Submit queues the updates. An update replaces a queued one for the same
+// driver unless it is older.
+func (w *CoalescingWriter) Submit(drivers []Driver) {
+	now := time.Now()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, d := range drivers {
+		w.submitted++
+		if queued, ok := w.pending[d.Id]; ok {
+			w.superseded++
+			if updateTime(queued.driver) > updateTime(d) {
+				continue
+			}
+		}
+		w.pending[d.Id] = coalescedUpdate{driver: d, received: now}
+	}
+}
+
+func updateTime(d Driver) int64 {
+	t, _ := strconv.ParseInt(d.LastUpdatedTime, 10, 64)
+	return t
+}
+
+// flush writes everything queued, up to coalesceFlushPipelines pipelines at a time.
+func (w *CoalescingWriter) flush() {
+	w.mu.Lock()
+	queued := w.pending
+	w.pending = make(map[int64]coalescedUpdate, len(queued))
+	w.mu.Unlock()
+	if len(queued) == 0 {
+		return
+	}
+
+	updates := make([]coalescedUpdate, 0, len(queued))
+	for _, u := range queued {
+		updates = append(updates, u)
+	}
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, coalesceFlushPipelines)
+	for from := 0; from < len(updates); from += coalesceFlushBatch {
+		chunk := updates[from:min(from+coalesceFlushBatch, len(updates))]
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			w.write(chunk)
+		}()
+	}
+	wg.Wait()
+
+	w.statsMu.Lock()
+	w.flushLatencies = append(w.flushLatencies, time.Since(start))
+	w.statsMu.Unlock()
+}
+
+func (w *CoalescingWriter) write(chunk []coalescedUpdate) {
+	drivers := make([]Driver, len(chunk))
+	for i, u := range chunk {
+		drivers[i] = u.driver
+	}
+
+	err := UpsertDrivers(drivers)
+	written := time.Now()
+
+	w.statsMu.Lock()
+	defer w.statsMu.Unlock()
+	if err != nil {
+		w.errors++
+		log.Printf("[Coalesce] Error flushing %d drivers: %v", len(drivers), err)
+		return
+	}
+	w.written += int64(len(drivers))
+	for _, u := range chunk {
+		w.freshness = append(w.freshness, written.Sub(u.received))
+	}
+}
+
+// Close flushes the remaining updates and reports on the run.
+func (w *CoalescingWriter) Close() CoalescingReport {
+	close(w.stop)
+	<-w.done
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.statsMu.Lock()
+	defer w.statsMu.Unlock()
+	return CoalescingReport{
+		Window:       w.window,
+		Submitted:    w.submitted,
+		Written:      w.written,
+		Superseded:   w.superseded,
+		Errors:       w.errors,
+		Flushes:      len(w.flushLatencies),
+		FlushLatency: summarizeLatencies(w.flushLatencies),
+		Freshness:    summarizeLatencies(w.freshness),
+	}
+}
+
+// runCoalescingBenchmark sends coalesceWritesPerMinute updates for
+// coalesceActiveDrivers drivers, first written directly batch by batch and
+// then through a CoalescingWriter for each of coalesceWindows.
+func runCoalescingBenchmark() {
+	reports := []CoalescingReport{measureDirectWrites()}
+	for _, window := range coalesceWindows {
+		fmt.Printf("[Coalesce] Flush window %v...\n", window)
+		writer := NewCoalescingWriter(window)
+		produceUpdates(writer.Submit)
+		reports = append(reports, writer.Close())
+	}
+	printCoalescingReport(reports)
+}
+
+// measureDirectWrites is the baseline: every producer batch is its own pipeline.
+func measureDirectWrites() CoalescingReport {
+	fmt.Println("[Coalesce] Direct writes...")
+	var (
+		mu        sync.Mutex
+		report    CoalescingReport
+		latencies []time.Duration
+		freshness []time.Duration
+	)
+	produceUpdates(func(drivers []Driver) {
+		start := time.Now()
+		err := UpsertDrivers(drivers)
+		elapsed := time.Since(start)
+
+		mu.Lock()
+		defer mu.Unlock()
+		report.Submitted += int64(len(drivers))
+		latencies = append(latencies, elapsed)
+		if err != nil {
+			report.Errors++
+			return
+		}
+		report.Written += int64(len(drivers))
+		for range drivers {
+			freshness = append(freshness, elapsed)
+		}
+	})
+
+	report.Flushes = len(latencies)
+	report.FlushLatency = summarizeLatencies(latencies)
+	report.Freshness = summarizeLatencies(freshness)
+	return report
+}
+
+// produceUpdates runs coalesceProducersCount producers for coalesceDuration.
+// Updates go to random drivers of the active fleet, so a driver is updated
+// about every coalesceActiveDrivers / coalesceWritesPerMinute minutes.
+func produceUpdates(send func(drivers []Driver)) {
+	deadline := time.Now().Add(coalesceDuration)
+	interval := time.Minute * writeBatchSize * coalesceProducersCount / coalesceWritesPerMinute
+
+	var wg sync.WaitGroup
+	for range coalesceProducersCount {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+
+			for time.Now().Before(deadline) {
+				drivers := make([]Driver, writeBatchSize)
+				for i := range drivers {
+					drivers[i] = GenerateFakeDriver(rand.Int63n(coalesceActiveDrivers) + 1)
+				}
+				send(drivers)
+				<-ticker.C
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func printCoalescingReport(reports []CoalescingReport) {
+	sort.SliceStable(reports, func(i, j int) bool { return reports[i].Window < reports[j].Window })
+
+	fmt.Println("\n|===== Write coalescing =====|")
+	fmt.Printf("%d updates per minute for %d active drivers, %d producers\n",
+		coalesceWritesPerMinute, coalesceActiveDrivers, coalesceProducersCount)
+	for _, r := range reports {
+		name := "direct"
+		if r.Window > 0 {
+			name = "window " + r.Window.String()
+		}
+		fmt.Printf("%-14s submitted %d, written %d (%.1f%% coalesced), errors %d\n",
+			name, r.Submitted, r.Written, r.CoalescingRatio()*100, r.Errors)
+		fmt.Printf("%-14s %d flushes, flush p50 %v, p99 %v, freshness p50 %v, p99 %v, max %v\n", "",
+			r.Flushes, r.FlushLatency.P50.Round(time.Microsecond), r.FlushLatency.P99.Round(time.Microsecond),
+			r.Freshness.P50.Round(time.Microsecond), r.Freshness.P99.Round(time.Microsecond), r.Freshness.Max.Round(time.Microsecond))
+	}
+}
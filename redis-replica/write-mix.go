@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Kinds of write in the benchmark write mix. Every batch holds one kind.
+const (
+	writeLocation = "location" // location pings, only location, geo_hash and last_updated_time
+	writeProfile  = "profile"  // tariff and score changes
+	writeFull     = "full"     // whole driver documents
+)
+
+var writeMix = []struct {
+	Kind  string
+	Share float64
+}{
+	{writeLocation, writeMixLocationShare},
+	{writeProfile, writeMixProfileShare},
+	{writeFull, writeMixFullShare},
+}
+
+func randomWriteKind() string {
+	r := rand.Float64()
+	for _, w := range writeMix {
+		if r < w.Share {
+			return w.Kind
+		}
+		r -= w.Share
+	}
+	return writeFull
+}
+
+// WriteMixStats collects the batch latency of each kind of write, so the cost
+// of the index update behind each kind can be compared.
+type WriteMixStats struct {
+	mu    sync.Mutex
+	kinds map[string]*writeKindStats
+}
+
+type writeKindStats struct {
+	Batches   int
+	Updates   int
+	Errors    int
+	Latencies []time.Duration
+}
+
+var writeMixStats atomic.Pointer[WriteMixStats]
+
+func init() {
+	resetWriteMix()
+}
+
+func resetWriteMix() {
+	writeMixStats.Store(&WriteMixStats{kinds: map[string]*writeKindStats{}})
+}
+
+func (s *WriteMixStats) record(kind string, updates int, latency time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.kinds[kind]
+	if !ok {
+		k = &writeKindStats{}
+		s.kinds[kind] = k
+	}
+	k.Batches++
+	k.Latencies = append(k.Latencies, latency)
+	if err != nil {
+		k.Errors++
+	} else {
+		k.Updates += updates
+	}
+}
+
+// plannedWrite is a generated batch, built before the clock starts.
+type plannedWrite struct {
+	Kind    string
+	Updates int
+	write   func() error
+}
+
+// planWriteBatch generates a batch of a random kind of the write mix. Location
+// and full batches include stale and duplicate pings, see buildWriteBatch.
+func planWriteBatch(size int) plannedWrite {
+	kind := randomWriteKind()
+	switch kind {
+	case writeLocation:
+		drivers, kinds := buildWriteBatch(size)
+		return plannedWrite{kind, len(drivers), func() error { return writeLocationBatch(drivers, kinds) }}
+	case writeProfile:
+		updates := make([]ProfileUpdate, size)
+		for i := range updates {
+			updates[i] = ProfileUpdate{Id: getNextDriverId(), ActiveTariffs: GetRandomTariffs(), Score: rand.Int63n(101)}
+		}
+		return plannedWrite{kind, len(updates), func() error { return UpdateDriverProfiles(updates) }}
+	}
+	drivers, kinds := buildWriteBatch(size)
+	return plannedWrite{writeFull, len(drivers), func() error { return writeBatch(drivers, kinds) }}
+}
+
+// run writes the batch and records its latency under its kind.
+func (p plannedWrite) run() error {
+	start := time.Now()
+	err := p.write()
+	writeMixStats.Load().record(p.Kind, p.Updates, time.Since(start), err)
+	return err
+}
+
+func printWriteMix() {
+	stats := writeMixStats.Load()
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	fmt.Println("\n|===== Write mix =====|")
+	for _, w := range writeMix {
+		k, ok := stats.kinds[w.Kind]
+		if !ok {
+			fmt.Printf("%-9s (%.0f%%) no batches\n", w.Kind, w.Share*100)
+			continue
+		}
+		l := summarizeLatencies(k.Latencies)
+		fmt.Printf("%-9s (%.0f%%) %d batches, %d updates, %d errors, batch p50 %v, p99 %v\n", w.Kind, w.Share*100,
+			k.Batches, k.Updates, k.Errors, l.P50.Round(time.Microsecond), l.P99.Round(time.Microsecond))
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// WriteStrategy is how a batch of driver writes is sent to the master.
+type WriteStrategy string
+
+const (
+	strategyPipeline   WriteStrategy = "pipeline"    // one EVALSHA per driver, pipelined
+	strategyTxPipeline WriteStrategy = "tx-pipeline" // the same wrapped in MULTI/EXEC
+	strategyLuaBulk    WriteStrategy = "lua-bulk"    // one EVALSHA for the whole batch
+	strategyPerCommand WriteStrategy = "per-command" // one EVALSHA and round trip per driver
+
+	// Plain HSETs without the upsert script. They skip the ordering check and
+	// exist to measure what the script costs, not to be used as writeStrategy.
+	strategyPlainPipeline   WriteStrategy = "plain-pipeline"    // HSET per driver, pipelined
+	strategyPlainTxPipeline WriteStrategy = "plain-tx-pipeline" // the same wrapped in MULTI/EXEC
+)
+
+var writeStrategies = []struct {
+	Strategy  WriteStrategy
+	Atomicity string
+}{
+	{strategyPipeline, "per driver; other clients interleave, a failed batch may be partly written"},
+	{strategyTxPipeline, "whole batch isolated (MULTI/EXEC); no rollback of drivers written before a failing command"},
+	{strategyLuaBulk, "whole batch isolated; a script error keeps earlier writes, blocks Redis for the whole batch"},
+	{strategyPerCommand, "per driver; a failure stops the batch, drivers before it are written"},
+	{strategyPlainPipeline, "NO ORDERING CHECK, stale updates overwrite newer ones; per driver, a failed batch may be partly written"},
+	{strategyPlainTxPipeline, "NO ORDERING CHECK, stale updates overwrite newer ones; whole batch isolated (MULTI/EXEC)"},
+}
+
+// bulkUpsertScript runs luaUpsertDriver for every driver of a batch. KEYS are
+// the heartbeat set followed by the driver keys, ARGV holds for every driver
+// the number of its arguments and the arguments. It returns 1 or 0 per driver.
+var bulkUpsertScript = redis.NewScript(luaUpsertDriver + `
+local applied = {}
+local i = 1
+for k = 2, #KEYS do
+	local n = tonumber(ARGV[i])
+	local a = {}
+	for j = 1, n do
+		a[j] = ARGV[i + j]
+	end
+	applied[k - 1] = upsert_driver(KEYS[k], KEYS[1], a)
+	i = i + n + 1
+end
+return applied
+`)
+
+// execDriverWritesWith runs the writes with the given strategy and reports for
+// every write whether it was applied.
+func execDriverWritesWith(strategy WriteStrategy, writes []driverWrite) ([]bool, error) {
+	if len(writes) == 0 {
+		return nil, nil
+	}
+
+	switch strategy {
+	case strategyPipeline:
+		return execPipelined(rdbMaster.Pipeline, writes)
+	case strategyTxPipeline:
+		return execTxPipelined(writes)
+	case strategyLuaBulk:
+		return execBulk(writes)
+	case strategyPerCommand:
+		applied := make([]bool, len(writes))
+		for i, w := range writes {
+			n, err := upsertDriverScript.Run(ctx, rdbMaster, w.keys, w.args...).Int64()
+			if err != nil {
+				return nil, err
+			}
+			applied[i] = n == 1
+		}
+		return applied, nil
+	case strategyPlainPipeline:
+		return execPlain(rdbMaster.Pipeline, writes)
+	case strategyPlainTxPipeline:
+		return execPlain(rdbMaster.TxPipeline, writes)
+	}
+	return nil, fmt.Errorf("unknown write strategy %q", strategy)
+}
+
+// execPipelined sends all writes in one round trip, loading the script first if
+// the master does not know it yet (e.g. after a failover).
+func execPipelined(newPipeline func() redis.Pipeliner, writes []driverWrite) ([]bool, error) {
+	for attempt := 0; ; attempt++ {
+		pipe := newPipeline()
+		cmds := make([]*redis.Cmd, len(writes))
+		for i, w := range writes {
+			cmds[i] = upsertDriverScript.EvalSha(ctx, pipe, w.keys, w.args...)
+		}
+
+		_, err := pipe.Exec(ctx)
+		if err != nil && redis.HasErrorPrefix(err, "NOSCRIPT") && attempt == 0 {
+			if err := upsertDriverScript.Load(ctx, rdbMaster).Err(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		applied := make([]bool, len(cmds))
+		for i, cmd := range cmds {
+			n, _ := cmd.Int64()
+			applied[i] = n == 1
+		}
+		return applied, nil
+	}
+}
+
+// txScriptLoaded reports whether upsertDriverScript was loaded for
+// execTxPipelined. It is cleared when the master answers NOSCRIPT.
+var txScriptLoaded atomic.Bool
+
+// execTxPipelined sends all writes in one MULTI/EXEC. Retrying a transaction
+// after NOSCRIPT could apply writes twice, so the script is loaded before the
+// MULTI instead. A batch that still hits NOSCRIPT (e.g. right after a failover)
+// fails, and the next one loads the script again.
+func execTxPipelined(writes []driverWrite) ([]bool, error) {
+	if !txScriptLoaded.Load() {
+		if err := upsertDriverScript.Load(ctx, rdbMaster).Err(); err != nil {
+			return nil, err
+		}
+		txScriptLoaded.Store(true)
+	}
+
+	pipe := rdbMaster.TxPipeline()
+	cmds := make([]*redis.Cmd, len(writes))
+	for i, w := range writes {
+		cmds[i] = upsertDriverScript.EvalSha(ctx, pipe, w.keys, w.args...)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		if redis.HasErrorPrefix(err, "NOSCRIPT") {
+			txScriptLoaded.Store(false)
+		}
+		return nil, err
+	}
+
+	applied := make([]bool, len(cmds))
+	for i, cmd := range cmds {
+		n, _ := cmd.Int64()
+		applied[i] = n == 1
+	}
+	return applied, nil
+}
+
+// execPlain writes the drivers with plain commands in one round trip. Every
+// write is applied, whatever the stored last_updated_time is. The status is
+// still only set on new drivers unless the write resets it, and a ping does not
+// bring an offline driver back.
+func execPlain(newPipeline func() redis.Pipeliner, writes []driverWrite) ([]bool, error) {
+	pipe := newPipeline()
+	for _, w := range writes {
+		key, heartbeats := w.keys[0], w.keys[1]
+		updateTime, id, mode := w.args[1].(string), w.args[2], w.args[3].(string)
+		if mode == writeModePartial {
+			// Without the script nothing stops a partial update from creating a driver
+			return nil, fmt.Errorf("plain writes do not support partial updates")
+		}
+
+		if storageMode == storageJSON {
+			if mode == writeModeReset {
+				pipe.Do(ctx, "JSON.SET", key, "$", w.args[5])
+			} else {
+				pipe.Do(ctx, "JSON.SET", key, "$", w.args[5], "NX")
+				pipe.Do(ctx, "JSON.MERGE", key, "$", w.args[6])
+			}
+		} else {
+			n := w.args[5].(int)
+			pipe.HSet(ctx, key, w.args[6:6+2*n]...)
+			for i := 6 + 2*n; i+1 < len(w.args); i += 2 {
+				if mode == writeModeReset {
+					pipe.HSet(ctx, key, w.args[i], w.args[i+1])
+				} else {
+					pipe.HSetNX(ctx, key, w.args[i].(string), w.args[i+1])
+				}
+			}
+		}
+
+		if updateTime != "" {
+			pipe.Do(ctx, "ZADD", heartbeats, updateTime, id)
+		}
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	applied := make([]bool, len(writes))
+	for i := range applied {
+		applied[i] = true
+	}
+	return applied, nil
+}
+
+func execBulk(writes []driverWrite) ([]bool, error) {
+	keys := []string{heartbeatKey}
+	args := []interface{}{}
+	for _, w := range writes {
+		keys = append(keys, w.keys[0])
+		args = append(args, len(w.args))
+		args = append(args, w.args...)
+	}
+
+	reply, err := bulkUpsertScript.Run(ctx, rdbMaster, keys, args...).Int64Slice()
+	if err != nil {
+		return nil, err
+	}
+	if len(reply) != len(writes) {
+		return nil, fmt.Errorf("unexpected reply %v", reply)
+	}
+
+	applied := make([]bool, len(reply))
+	for i, n := range reply {
+		applied[i] = n == 1
+	}
+	return applied, nil
+}
+
+type strategyResult struct {
+	Strategy     WriteStrategy
+	Atomicity    string
+	Operations   int64
+	Errors       int64 // drivers in failed batches, same unit as Operations
+	OpsPerMinute float64
+	Latency      LatencySummary
+}
+
+// runWriteStrategyMatrix writes batches of writeBatchSize drivers with every
+// strategy in turn, strategyGoroutinesCount writers for strategyStepDuration each.
+func runWriteStrategyMatrix() {
+	results := make([]strategyResult, 0, len(writeStrategies))
+	for _, s := range writeStrategies {
+		fmt.Printf("[Strategies] %s: %d writers for %v...\n", s.Strategy, strategyGoroutinesCount, strategyStepDuration)
+		result := measureWriteStrategy(s.Strategy)
+		result.Atomicity = s.Atomicity
+		results = append(results, result)
+	}
+	printWriteStrategyMatrix(results)
+}
+
+func measureWriteStrategy(strategy WriteStrategy) strategyResult {
+	var (
+		result    = strategyResult{Strategy: strategy}
+		mu        sync.Mutex
+		latencies []time.Duration
+		wg        sync.WaitGroup
+	)
+
+	start := time.Now()
+	deadline := start.Add(strategyStepDuration)
+	for range strategyGoroutinesCount {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			local := []time.Duration{}
+			for time.Now().Before(deadline) {
+				drivers := make([]Driver, writeBatchSize)
+				for i := range drivers {
+					drivers[i] = GenerateFakeDriver(getNextDriverId())
+				}
+				writes, err := buildDriverWrites(drivers, false)
+				if err != nil {
+					atomic.AddInt64(&result.Errors, int64(len(drivers)))
+					continue
+				}
+
+				callStart := time.Now()
+				_, err = execDriverWritesWith(strategy, writes)
+				local = append(local, time.Since(callStart))
+				if err != nil {
+					atomic.AddInt64(&result.Errors, int64(len(drivers)))
+					log.Printf("[Strategies] %s: error writing batch: %v", strategy, err)
+					continue
+				}
+				atomic.AddInt64(&result.Operations, int64(len(drivers)))
+			}
+
+			mu.Lock()
+			latencies = append(latencies, local...)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	result.OpsPerMinute = float64(result.Operations) / time.Since(start).Minutes()
+	result.Latency = summarizeLatencies(latencies)
+	return result
+}
+
+func printWriteStrategyMatrix(results []strategyResult) {
+	fmt.Println("\n|===== Write strategies =====|")
+	fmt.Printf("Batches of %d drivers, %d writers\n", writeBatchSize, strategyGoroutinesCount)
+	for _, r := range results {
+		fmt.Printf("%-18s %10.0f writes/min, errors %d, batch p50 %v, p99 %v, max %v\n", r.Strategy,
+			r.OpsPerMinute, r.Errors, r.Latency.P50.Round(time.Microsecond), r.Latency.P99.Round(time.Microsecond), r.Latency.Max.Round(time.Microsecond))
+		fmt.Printf("%-18s atomicity: %s\n", "", r.Atomicity)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+// SchemaField describes one attribute of the RediSearch index.
+type SchemaField struct {
+	Name      string
+	Type      string // NUMERIC, GEO, TEXT or TAG
+	Separator string // TAG only, RediSearch defaults to ","
+	Sortable  bool
+	NoIndex   bool
+}
+
+// driverSchema is the single definition of the driver index, FT.CREATE is derived
+// from it.
+var driverSchema = []SchemaField{
+	{Name: "driver_id", Type: "NUMERIC", Sortable: true},
+	{Name: "location", Type: "GEO"},
+	{Name: "geo_hash", Type: "TEXT"},
+	{Name: "active_tariffs", Type: "TAG", Separator: "|"},
+	{Name: "score", Type: "NUMERIC", Sortable: true},
+	{Name: "active", Type: "TAG"},
+	{Name: "phone_charge_percent", Type: "NUMERIC", NoIndex: true},
+	{Name: "last_updated_time", Type: "NUMERIC", NoIndex: true},
+}
+
+// createIndexArgs builds the FT.CREATE command for the given schema over driver hashes.
+func createIndexArgs(index string, schema []SchemaField) []interface{} {
+	args := []interface{}{"FT.CREATE", index, "ON", "HASH", "PREFIX", "1", "driver:", "SCHEMA"}
+	for _, f := range schema {
+		args = append(args, f.Name, f.Type)
+		if f.Separator != "" {
+			args = append(args, "SEPARATOR", f.Separator)
+		}
+		if f.Sortable {
+			args = append(args, "SORTABLE")
+		}
+		if f.NoIndex {
+			args = append(args, "NOINDEX")
+		}
+	}
+	return args
+}
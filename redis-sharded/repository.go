@@ -0,0 +1,378 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type Location struct {
+	Lat  float64
+	Long float64
+}
+
+var ActiveTariffs []string = []string{"start", "camfort|camfort+", "business"}
+
+type Driver struct {
+	Id              int64
+	GeoHash         string
+	Location        Location
+	ActiveTariffs   []string
+	Score           int64
+	Charge          int64
+	Active          bool
+	LastUpdatedTime string
+}
+
+// Drivers live on the shard of their region as driver:<id>. driver_region:<id>,
+// stored on the shard picked by the driver id, remembers the current region so
+// the driver can be found by id and cleaned up when it moves to another shard.
+func driverKey(id int64) string {
+	return fmt.Sprintf("driver:%d", id)
+}
+
+func driverRegionKey(id int64) string {
+	return fmt.Sprintf("driver_region:%d", id)
+}
+
+// regionSwapScript moves driver_region:<id> from ARGV[1] ("" for a new driver)
+// to ARGV[2]. It replies 1, or 0 when another writer changed the region since
+// it was read.
+var regionSwapScript = redis.NewScript(`
+if (redis.call('GET', KEYS[1]) or '') ~= ARGV[1] then
+	return 0
+end
+redis.call('SET', KEYS[1], ARGV[2])
+return 1
+`)
+
+// Create driver if not exists if exists update it. The region pointer only
+// moves if nobody else moved it since it was read, drivers that lost such a
+// race are written again, so a driver never stays on two shards.
+func UpsertDrivers(drivers []Driver) error {
+	pending := drivers
+	for attempt := 0; len(pending) > 0; attempt++ {
+		if attempt == regionMoveRetries {
+			return fmt.Errorf("%d drivers changed region concurrently %d times in a row", len(pending), attempt)
+		}
+		var err error
+		if pending, err = upsertDriversOnce(pending); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// upsertDriversOnce writes the drivers and returns the ones whose region pointer
+// was changed concurrently.
+func upsertDriversOnce(drivers []Driver) ([]Driver, error) {
+	// Read the current region and write the new copy, one round trip per shard
+	pipe := shards.Pipeline()
+	previous := make([]*redis.StringCmd, len(drivers))
+	for i, in := range drivers {
+		previous[i] = pipe.On(shards.ForDriver(in.Id)).Get(ctx, driverRegionKey(in.Id))
+
+		fields := map[string]interface{}{
+			"driver_id": in.Id,
+			// RediSearch GEO fields expect "lon,lat"
+			"location":             fmt.Sprintf("%f,%f", in.Location.Long, in.Location.Lat),
+			"geo_hash":             in.GeoHash,
+			"active_tariffs":       strings.Join(in.ActiveTariffs, "|"),
+			"score":                in.Score,
+			"active":               fmt.Sprintf("%t", in.Active),
+			"phone_charge_percent": in.Charge,
+			"last_updated_time":    in.LastUpdatedTime,
+		}
+		pipe.On(shards.ForRegion(regionOf(in.GeoHash))).HSet(ctx, driverKey(in.Id), fields)
+	}
+	if err := pipe.Exec(); err != nil {
+		return nil, err
+	}
+
+	// Move the region pointers, each one only if it is still what was read
+	swap := shards.Pipeline()
+	swaps := make([]*redis.Cmd, len(drivers))
+	for i, in := range drivers {
+		old, _ := previous[i].Result()
+		swaps[i] = regionSwapScript.Eval(ctx, swap.On(shards.ForDriver(in.Id)), []string{driverRegionKey(in.Id)}, old, regionOf(in.GeoHash))
+	}
+	if err := swap.Exec(); err != nil {
+		return nil, err
+	}
+
+	// The driver moved to a region on another shard, remove the stale copy there
+	cleanup := shards.Pipeline()
+	lost := []Driver{}
+	for i, in := range drivers {
+		moved, err := swaps[i].Int64()
+		if err != nil {
+			return nil, err
+		}
+		if moved == 0 {
+			lost = append(lost, in)
+			continue
+		}
+		old, _ := previous[i].Result()
+		if old == "" {
+			continue
+		}
+		if oldShard := shards.ForRegion(old); oldShard != shards.ForRegion(regionOf(in.GeoHash)) {
+			cleanup.On(oldShard).Del(ctx, driverKey(in.Id))
+		}
+	}
+	if err := cleanup.Exec(); err != nil {
+		return nil, err
+	}
+	return lost, nil
+}
+
+func GetDriver(id int64) (Driver, error) {
+	region, err := shards.ForDriver(id).Get(ctx, driverRegionKey(id)).Result()
+	if err == redis.Nil {
+		return Driver{}, nil
+	}
+	if err != nil {
+		return Driver{}, err
+	}
+
+	// Get all fields from the hash
+	result, err := shards.ForRegion(region).HGetAll(ctx, driverKey(id)).Result()
+	if err != nil {
+		return Driver{}, err
+	}
+
+	if len(result) == 0 {
+		return Driver{}, nil
+	}
+
+	return parseDriver(result), nil
+}
+
+// Parse hash fields into Driver struct
+func parseDriver(result map[string]string) Driver {
+	driver := Driver{}
+
+	if driverId, ok := result["driver_id"]; ok {
+		if id, err := strconv.ParseInt(driverId, 10, 64); err == nil {
+			driver.Id = id
+		}
+	}
+
+	if location, ok := result["location"]; ok {
+		parts := strings.Split(location, ",")
+		if len(parts) == 2 {
+			if lng, err := strconv.ParseFloat(parts[0], 64); err == nil {
+				driver.Location.Long = lng
+			}
+			if lat, err := strconv.ParseFloat(parts[1], 64); err == nil {
+				driver.Location.Lat = lat
+			}
+		}
+	}
+
+	if geoHash, ok := result["geo_hash"]; ok {
+		driver.GeoHash = geoHash
+	}
+
+	if activeTariffs, ok := result["active_tariffs"]; ok {
+		driver.ActiveTariffs = strings.Split(activeTariffs, "|")
+	}
+
+	if score, ok := result["score"]; ok {
+		if s, err := strconv.ParseInt(score, 10, 64); err == nil {
+			driver.Score = s
+		}
+	}
+
+	if active, ok := result["active"]; ok {
+		driver.Active = active == "true"
+	}
+
+	if charge, ok := result["phone_charge_percent"]; ok {
+		if c, err := strconv.ParseInt(charge, 10, 64); err == nil {
+			driver.Charge = c
+		}
+	}
+
+	if lastUpdated, ok := result["last_updated_time"]; ok {
+		driver.LastUpdatedTime = lastUpdated
+	}
+
+	return driver
+}
+
+// In response sort by driver_id field
+func GetDriverInRadius(location Location, radiusKm float64, limit int) ([]Driver, error) {
+	// Build the search query for geospatial search
+	query := fmt.Sprintf("@location:[%f %f %f km]", location.Long, location.Lat, radiusKm)
+
+	// Only the shards owning a region that overlaps the circle can have matches
+	targets := shards.ForRegions(regionsInRadius(location, radiusKm))
+
+	drivers, err := scatterSearch(targets, query, "driver_id", "ASC", limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return mergeDrivers(drivers, func(a, b Driver) bool { return a.Id < b.Id }, limit), nil
+}
+
+// In response sort by score field
+func GetDriverForOrder(geoHash string, tariffs []string, limit int) ([]Driver, error) {
+	// Build the search query
+	var queryParts []string
+
+	// Add geo_hash filter if provided
+	if geoHash != "" {
+		queryParts = append(queryParts, fmt.Sprintf("@geo_hash:%s*", geoHash))
+	}
+
+	// Add active filter
+	queryParts = append(queryParts, "@active:{true}")
+	queryParts = append(queryParts, fmt.Sprintf("@active_tariffs:{%s}", strings.Join(tariffs, "|")))
+
+	query := strings.Join(queryParts, " ")
+
+	// A prefix at least as long as a region pins the query to one shard,
+	// shorter or missing prefixes have to ask every shard
+	targets := shards.All()
+	if len(geoHash) >= regionPrecision {
+		targets = []*redis.Client{shards.ForRegion(regionOf(geoHash))}
+	}
+
+	drivers, err := scatterSearch(targets, query, "score", "DESC", limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return mergeDrivers(drivers, func(a, b Driver) bool { return a.Score > b.Score }, limit), nil
+}
+
+// scatterSearch runs the query against the index of every shard in parallel and
+// returns the union of the results. Each shard applies SORTBY and LIMIT itself,
+// so the caller only has to merge and cut the combined list.
+func scatterSearch(targets []*redis.Client, query, sortBy, order string, limit int) ([]Driver, error) {
+	var (
+		wg       sync.WaitGroup
+		mt       sync.Mutex
+		drivers  []Driver
+		firstErr error
+	)
+
+	for _, shard := range targets {
+		wg.Add(1)
+		go func(shard *redis.Client) {
+			defer wg.Done()
+			found, err := searchShard(shard, query, sortBy, order, limit)
+
+			mt.Lock()
+			defer mt.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("%s: %w", shard.Options().Addr, err)
+				}
+				return
+			}
+			drivers = append(drivers, found...)
+		}(shard)
+	}
+	wg.Wait()
+
+	return drivers, firstErr
+}
+
+func searchShard(shard *redis.Client, query, sortBy, order string, limit int) ([]Driver, error) {
+	searchResult, err := shard.Do(ctx, "FT.SEARCH", indexName, query, "SORTBY", sortBy, order, "LIMIT", 0, limit).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse the search result
+	results, ok := searchResult.([]interface{})
+	if !ok || len(results) < 2 {
+		return []Driver{}, nil
+	}
+
+	// Skip the first element (total count), the rest are key / field list pairs
+	driverData := results[1:]
+	drivers := make([]Driver, 0, len(driverData)/2)
+
+	for i := 0; i+1 < len(driverData); i += 2 {
+		fieldList, ok := driverData[i+1].([]interface{})
+		if !ok {
+			continue
+		}
+
+		fields := make(map[string]string, len(fieldList)/2)
+		for j := 0; j+1 < len(fieldList); j += 2 {
+			fields[fmt.Sprint(fieldList[j])] = fmt.Sprint(fieldList[j+1])
+		}
+
+		drivers = append(drivers, parseDriver(fields))
+	}
+
+	return drivers, nil
+}
+
+// mergeDrivers combines the results of several shards: a driver found on two
+// shards while it moves between regions is kept once, with its newest update,
+// then the drivers are sorted by less and cut to the limit.
+func mergeDrivers(drivers []Driver, less func(a, b Driver) bool, limit int) []Driver {
+	index := make(map[int64]int, len(drivers))
+	merged := make([]Driver, 0, len(drivers))
+	for _, d := range drivers {
+		i, seen := index[d.Id]
+		if !seen {
+			index[d.Id] = len(merged)
+			merged = append(merged, d)
+			continue
+		}
+		if newerUpdate(d.LastUpdatedTime, merged[i].LastUpdatedTime) {
+			merged[i] = d
+		}
+	}
+
+	sort.SliceStable(merged, func(i, j int) bool { return less(merged[i], merged[j]) })
+	return truncate(merged, limit)
+}
+
+// newerUpdate compares two last_updated_time values in unix milliseconds.
+func newerUpdate(a, b string) bool {
+	ta, errA := strconv.ParseInt(a, 10, 64)
+	tb, errB := strconv.ParseInt(b, 10, 64)
+	if errA != nil || errB != nil {
+		return a > b
+	}
+	return ta > tb
+}
+
+func truncate(drivers []Driver, limit int) []Driver {
+	if len(drivers) > limit {
+		return drivers[:limit]
+	}
+	return drivers
+}
+
+// createShardIndexes creates the driver index on every shard, each one covers
+// the drivers stored on its own server only.
+func createShardIndexes() error {
+	for _, shard := range shards.All() {
+		indexes, err := shard.Do(ctx, "FT._LIST").StringSlice()
+		if err != nil {
+			return err
+		}
+		if slices.Contains(indexes, indexName) {
+			continue
+		}
+
+		if err := shard.Do(ctx, createIndexArgs(indexName, driverSchema)...).Err(); err != nil {
+			return fmt.Errorf("%s: %w", shard.Options().Addr, err)
+		}
+	}
+	return nil
+}
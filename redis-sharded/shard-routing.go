@@ -0,0 +1,164 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"sync"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/dgryski/go-rendezvous"
+	"github.com/pierrre/geohash"
+	"github.com/redis/go-redis/v9"
+)
+
+// ShardedClient partitions keys over independent single-instance Redis servers
+// with rendezvous hashing, so adding or removing a server only moves the keys
+// that hash to it.
+type ShardedClient struct {
+	shards map[string]*redis.Client
+	addrs  []string
+	ring   *rendezvous.Rendezvous
+}
+
+func NewShardedClient(addrs []string) (*ShardedClient, error) {
+	shards := map[string]*redis.Client{}
+	for _, addr := range addrs {
+		client := redis.NewClient(&redis.Options{
+			Addr: addr,
+			// FT.SEARCH replies are parsed in their RESP2 array form
+			Protocol: 2,
+		})
+
+		if _, err := client.Ping(ctx).Result(); err != nil {
+			return nil, err
+		}
+		shards[addr] = client
+	}
+
+	return &ShardedClient{
+		shards: shards,
+		addrs:  addrs,
+		ring:   rendezvous.New(addrs, xxhash.Sum64String),
+	}, nil
+}
+
+// ForRegion returns the shard storing the drivers of a region.
+func (s *ShardedClient) ForRegion(region string) *redis.Client {
+	return s.shards[s.ring.Lookup("region:"+region)]
+}
+
+// ForDriver returns the shard storing the region pointer of a driver.
+func (s *ShardedClient) ForDriver(id int64) *redis.Client {
+	return s.shards[s.ring.Lookup("driver:"+strconv.FormatInt(id, 10))]
+}
+
+// ForRegions returns the distinct shards storing any of the regions.
+func (s *ShardedClient) ForRegions(regions []string) []*redis.Client {
+	seen := map[string]bool{}
+	clients := []*redis.Client{}
+	for _, region := range regions {
+		addr := s.ring.Lookup("region:" + region)
+		if !seen[addr] {
+			seen[addr] = true
+			clients = append(clients, s.shards[addr])
+		}
+	}
+	return clients
+}
+
+func (s *ShardedClient) All() []*redis.Client {
+	clients := make([]*redis.Client, 0, len(s.addrs))
+	for _, addr := range s.addrs {
+		clients = append(clients, s.shards[addr])
+	}
+	return clients
+}
+
+// ShardedPipeline queues commands per shard and executes all shard pipelines in
+// parallel, one round trip per shard.
+type ShardedPipeline struct {
+	pipes map[*redis.Client]redis.Pipeliner
+}
+
+func (s *ShardedClient) Pipeline() *ShardedPipeline {
+	return &ShardedPipeline{pipes: map[*redis.Client]redis.Pipeliner{}}
+}
+
+func (p *ShardedPipeline) On(client *redis.Client) redis.Pipeliner {
+	pipe, ok := p.pipes[client]
+	if !ok {
+		pipe = client.Pipeline()
+		p.pipes[client] = pipe
+	}
+	return pipe
+}
+
+// Exec runs every shard pipeline and returns the first error other than redis.Nil.
+func (p *ShardedPipeline) Exec() error {
+	var (
+		wg       sync.WaitGroup
+		mt       sync.Mutex
+		firstErr error
+	)
+	for _, pipe := range p.pipes {
+		wg.Add(1)
+		go func(pipe redis.Pipeliner) {
+			defer wg.Done()
+			if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+				mt.Lock()
+				defer mt.Unlock()
+				if firstErr == nil {
+					firstErr = err
+				}
+			}
+		}(pipe)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// regionOf maps a driver geohash to its sharding region, the first
+// regionPrecision characters. A precision of 3 gives cells of roughly
+// 156km x 156km, so a city stays on one shard.
+func regionOf(geoHash string) string {
+	if len(geoHash) < regionPrecision {
+		return geoHash
+	}
+	return geoHash[:regionPrecision]
+}
+
+// regionsInRadius returns every region overlapping the bounding box of the circle.
+func regionsInRadius(center Location, radiusKm float64) []string {
+	const kmPerDegree = 111.32
+	dLat := radiusKm / kmPerDegree
+	dLng := radiusKm / (kmPerDegree * math.Max(math.Cos(center.Lat*math.Pi/180), 0.01))
+
+	// Step through the box one region cell at a time
+	cell, _ := geohash.Decode(geohash.Encode(center.Lat, center.Long, regionPrecision))
+	stepLat := cell.Lat.Max - cell.Lat.Min
+	stepLng := cell.Lon.Max - cell.Lon.Min
+
+	seen := map[string]bool{}
+	regions := []string{}
+	add := func(lat, lng float64) {
+		region := geohash.Encode(math.Max(-90, math.Min(90, lat)), lng, regionPrecision)
+		if !seen[region] {
+			seen[region] = true
+			regions = append(regions, region)
+		}
+	}
+
+	for lat := center.Lat - dLat; ; lat = math.Min(lat+stepLat, center.Lat+dLat) {
+		for lng := center.Long - dLng; ; lng = math.Min(lng+stepLng, center.Long+dLng) {
+			add(lat, lng)
+			if lng >= center.Long+dLng {
+				break
+			}
+		}
+		if lat >= center.Lat+dLat {
+			break
+		}
+	}
+
+	return regions
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/dgryski/go-rendezvous"
+	"github.com/pierrre/geohash"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestRegionOf(t *testing.T) {
+	tests := []struct {
+		geoHash string
+		want    string
+	}{
+		{"txkg0b", "txk"},
+		{"txk", "txk"},
+		{"tx", "tx"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := regionOf(tt.geoHash); got != tt.want {
+			t.Errorf("regionOf(%q) = %q, want %q", tt.geoHash, got, tt.want)
+		}
+	}
+}
+
+func TestRegionsInRadius(t *testing.T) {
+	// Tashkent lies inside one region cell, the tests place points relative to it
+	cell, _ := geohash.Decode(geohash.Encode(41.3, 69.24, regionPrecision))
+	midLat := (cell.Lat.Min + cell.Lat.Max) / 2
+	midLng := (cell.Lon.Min + cell.Lon.Max) / 2
+	home := geohash.Encode(midLat, midLng, regionPrecision)
+	north := geohash.Encode(cell.Lat.Max+0.01, midLng, regionPrecision)
+	east := geohash.Encode(midLat, cell.Lon.Max+0.01, regionPrecision)
+	northEast := geohash.Encode(cell.Lat.Max+0.01, cell.Lon.Max+0.01, regionPrecision)
+
+	tests := []struct {
+		name     string
+		center   Location
+		radiusKm float64
+		want     []string // exact result, nil to only check contains
+		contains []string
+		minCount int
+	}{
+		{"zero radius", Location{midLat, midLng}, 0, []string{home}, nil, 1},
+		{"inside one cell", Location{midLat, midLng}, 5, []string{home}, nil, 1},
+		{"crosses north border", Location{cell.Lat.Max - 0.01, midLng}, 5, nil, []string{home, north}, 2},
+		{"crosses east border", Location{midLat, cell.Lon.Max - 0.01}, 5, nil, []string{home, east}, 2},
+		{"crosses corner", Location{cell.Lat.Max - 0.01, cell.Lon.Max - 0.01}, 5, nil, []string{home, north, east, northEast}, 4},
+		{"spans several cells", Location{midLat, midLng}, 300, nil, []string{home, north, east, northEast}, 9},
+		{"near the pole", Location{89.95, 10}, 50, nil, []string{geohash.Encode(89.95, 10, regionPrecision)}, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := regionsInRadius(tt.center, tt.radiusKm)
+			if tt.want != nil && !slices.Equal(got, tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for _, region := range tt.contains {
+				if !slices.Contains(got, region) {
+					t.Errorf("%v does not contain %q", got, region)
+				}
+			}
+			if len(got) < tt.minCount {
+				t.Errorf("got %d regions, want at least %d", len(got), tt.minCount)
+			}
+			seen := map[string]bool{}
+			for _, region := range got {
+				if len(region) != regionPrecision {
+					t.Errorf("region %q has length %d", region, len(region))
+				}
+				if seen[region] {
+					t.Errorf("region %q returned twice", region)
+				}
+				seen[region] = true
+			}
+		})
+	}
+}
+
+// testShards builds a ShardedClient without connecting to the servers.
+func testShards(addrs ...string) *ShardedClient {
+	clients := map[string]*redis.Client{}
+	for _, addr := range addrs {
+		clients[addr] = redis.NewClient(&redis.Options{Addr: addr})
+	}
+	return &ShardedClient{shards: clients, addrs: addrs, ring: rendezvous.New(addrs, xxhash.Sum64String)}
+}
+
+func TestForRegions(t *testing.T) {
+	s := testShards("a:6391", "b:6392", "c:6393")
+	regions := []string{"txk", "txm", "txs", "u33", "dr5", "9q8", "gcp", "xn7"}
+
+	tests := []struct {
+		name    string
+		regions []string
+	}{
+		{"none", nil},
+		{"one", regions[:1]},
+		{"repeated", []string{"txk", "txk", "txk"}},
+		{"many", regions},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := s.ForRegions(tt.regions)
+
+			// Every region's shard once, in first-seen order
+			want := []*redis.Client{}
+			for _, region := range tt.regions {
+				if shard := s.ForRegion(region); !slices.Contains(want, shard) {
+					want = append(want, shard)
+				}
+			}
+			if !slices.Equal(got, want) {
+				t.Errorf("got %d shards, want %d", len(got), len(want))
+			}
+		})
+	}
+
+	// Routing only depends on the server list, not on the order it is given in
+	reordered := testShards("c:6393", "a:6391", "b:6392")
+	for _, region := range regions {
+		if got, want := reordered.ForRegion(region).Options().Addr, s.ForRegion(region).Options().Addr; got != want {
+			t.Errorf("region %q: %s after reordering, %s before", region, got, want)
+		}
+	}
+
+	// Removing a server only moves the regions it owned
+	smaller := testShards("a:6391", "b:6392")
+	for _, region := range regions {
+		before := s.ForRegion(region).Options().Addr
+		if before != "c:6393" && smaller.ForRegion(region).Options().Addr != before {
+			t.Errorf("region %q moved from %s although its server stayed", region, before)
+		}
+	}
+}
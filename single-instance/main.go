@@ -22,6 +22,9 @@ const (
 	testCycleCount              = 1
 	writeGoroutinesCount        = 20
 	readGoroutinesCount         = 25
+	seedGoroutinesCount         = 10
+	seedBatchSize               = 1_000
+	warmupDuration              = time.Second * 30
 	numDrivers                  = 1_000_000
 	writeOpsPerMinute           = 1_000_000
 	singleGetOpsPerMinute       = 1_000_000
@@ -49,20 +52,18 @@ func main() {
 	fmt.Println("Database flushed successfully.")
 
 	fmt.Println("Creating index...")
-	_, err := rdb.Do(ctx, "FT.CREATE", "index", "ON", "HASH", "PREFIX", "1", "driver:", "SCHEMA",
-		"driver_id", "NUMERIC", "SORTABLE",
-		"location", "GEO",
-		"geo_hash", "TEXT",
-		"active_tariffs", "TAG", "SEPARATOR", "|",
-		"score", "NUMERIC", "SORTABLE",
-		"active", "TAG",
-		"phone_charge_percent", "NUMERIC", "NOINDEX",
-		"last_updated_time", "NUMERIC", "NOINDEX",
-	).Result()
+	_, err := rdb.Do(ctx, createIndexArgs("index", driverSchema)...).Result()
 	if err != nil {
 		log.Fatalf("Failed to create index: %v", err)
 	}
 
+	if err := SeedDrivers(); err != nil {
+		log.Fatalf("Failed to seed drivers: %v", err)
+	}
+	Warmup()
+
+	fmt.Println("Starting measurement...")
+
 	var (
 		totalWriteOperations int
 		totalWriteErrors     int
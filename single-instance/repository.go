@@ -28,8 +28,26 @@ type Driver struct {
 func UpsertDriver(in Driver) error {
 	key := fmt.Sprintf("driver:%d", in.Id)
 
-	// Prepare the hash fields
-	fields := map[string]interface{}{
+	// Use HSet to create or update the driver
+	return rdb.HSet(ctx, key, driverFields(in)).Err()
+}
+
+// Upsert many drivers in one pipelined round trip.
+func UpsertDrivers(drivers []Driver) error {
+	pipe := rdb.Pipeline() // batch all commands
+
+	for _, in := range drivers {
+		pipe.HSet(ctx, fmt.Sprintf("driver:%d", in.Id), driverFields(in))
+	}
+
+	// Execute all queued commands in one round trip
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// Prepare the hash fields
+func driverFields(in Driver) map[string]interface{} {
+	return map[string]interface{}{
 		"driver_id":            in.Id,
 		"location":             fmt.Sprintf("%f,%f", in.Location.Lat, in.Location.Long),
 		"geo_hash":             in.GeoHash,
@@ -39,9 +57,6 @@ func UpsertDriver(in Driver) error {
 		"phone_charge_percent": in.Charge,
 		"last_updated_time":    in.LastUpdatedTime,
 	}
-
-	// Use HSet to create or update the driver
-	return rdb.HSet(ctx, key, fields).Err()
 }
 
 func GetDriver(id int64) (Driver, error) {
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SeedDrivers bulk-loads drivers 1..numDrivers into Redis with pipelined
+// batches, reporting progress and load throughput.
+func SeedDrivers() error {
+	fmt.Printf("Seeding %d drivers...\n", numDrivers)
+
+	var (
+		seeded   atomic.Int64
+		firstErr error
+		errOnce  sync.Once
+		wg       sync.WaitGroup
+	)
+	ids := make(chan int64, seedGoroutinesCount)
+	startTime := time.Now()
+
+	// Report progress once per second until seeding finishes
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				count := seeded.Load()
+				fmt.Printf("  seeded %d/%d drivers (%.1f%%), %.0f drivers/s\n",
+					count, numDrivers, float64(count)*100/numDrivers, float64(count)/time.Since(startTime).Seconds())
+			}
+		}
+	}()
+
+	for range seedGoroutinesCount {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for first := range ids {
+				last := min(first+seedBatchSize-1, numDrivers)
+				drivers := make([]Driver, 0, last-first+1)
+				for id := first; id <= last; id++ {
+					drivers = append(drivers, GenerateFakeDriver(id))
+				}
+
+				if err := UpsertDrivers(drivers); err != nil {
+					log.Printf("Seeding: error writing drivers %d-%d: %v", first, last, err)
+					errOnce.Do(func() { firstErr = err })
+					continue
+				}
+				seeded.Add(int64(len(drivers)))
+			}
+		}()
+	}
+
+	for first := int64(1); first <= numDrivers; first += seedBatchSize {
+		ids <- first
+	}
+	close(ids)
+	wg.Wait()
+	close(done)
+
+	elapsed := time.Since(startTime)
+	fmt.Printf("Seeded %d drivers in %v (%.0f drivers/s)\n",
+		seeded.Load(), elapsed.Round(time.Millisecond), float64(seeded.Load())/elapsed.Seconds())
+
+	return firstErr
+}
+
+// Warmup runs all four workloads for warmupDuration and discards the results,
+// so caches and connection pools are warm when measurement starts.
+func Warmup() {
+	if warmupDuration <= 0 {
+		return
+	}
+
+	fmt.Printf("Warming up for %v...\n", warmupDuration)
+	ops := []func() error{
+		func() error {
+			return UpsertDriver(GenerateFakeDriver(getNextDriverId()))
+		},
+		func() error {
+			_, err := GetDriver(getNextDriverIdRead())
+			return err
+		},
+		func() error {
+			lat, lng, _ := GetRandomLatLong()
+			_, err := GetDriverInRadius(Location{Lat: lat, Long: lng}, 5, 30)
+			return err
+		},
+		func() error {
+			_, _, geohash := GetRandomLatLong()
+			_, err := GetDriverForOrder(geohash, GetRandomTariffs(), 5)
+			return err
+		},
+	}
+
+	var (
+		wg         sync.WaitGroup
+		operations atomic.Int64
+		errorCount atomic.Int64
+	)
+	startTime := time.Now()
+	for i := range readGoroutinesCount {
+		wg.Add(1)
+		go func(workerID int) {
+			defer wg.Done()
+			for time.Since(startTime) < warmupDuration {
+				if err := ops[workerID%len(ops)](); err != nil {
+					errorCount.Add(1)
+				} else {
+					operations.Add(1)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	fmt.Printf("Warmup finished: %d operations, %d errors (discarded)\n", operations.Load(), errorCount.Load())
+}